@@ -0,0 +1,46 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package actionstest
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jeesmon/operator-utils/actions"
+	"github.com/stretchr/testify/assert"
+)
+
+var updateGolden = flag.Bool("update-golden", false, "rewrite the golden files compared by actionstest")
+
+// AssertGoldenPlan compares plan, serialized as indented JSON, with the content of
+// file. Run the tests with -update-golden to write the current plans to their files.
+func AssertGoldenPlan(t testing.TB, plan actions.Plan, file string) {
+	t.Helper()
+	data, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		t.Fatalf("unable to serialize plan: %v", err)
+	}
+	data = append(data, '\n')
+
+	if *updateGolden {
+		err = os.MkdirAll(filepath.Dir(file), 0755)
+		if err == nil {
+			err = os.WriteFile(file, data, 0644)
+		}
+		if err != nil {
+			t.Fatalf("unable to write golden file: %v", err)
+		}
+		return
+	}
+
+	expected, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatalf("unable to read golden file, run with -update-golden to create it: %v", err)
+	}
+	assert.Equal(t, string(expected), string(data), "plan differs from %s", file)
+}
@@ -0,0 +1,499 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package actionstest provides an actions.ActionRunner that records the calls of the
+// actions instead of running them against a cluster, and assertions on the recording.
+package actionstest
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/jeesmon/operator-utils/actions"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/tools/reference"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// Method is the ActionRunner method an action called
+type Method string
+
+const (
+	MethodCreate          Method = "Create"
+	MethodUpdate          Method = "Update"
+	MethodMutate          Method = "Mutate"
+	MethodUpdateIfChanged Method = "UpdateIfChanged"
+	MethodApply           Method = "Apply"
+	MethodCreateOrUpdate  Method = "CreateOrUpdate"
+	MethodCreateOrAdopt   Method = "CreateOrAdopt"
+	MethodRecreate        Method = "Recreate"
+	MethodDelete          Method = "Delete"
+	MethodDeleteAllOf     Method = "DeleteAllOf"
+	MethodWaitForDeletion Method = "WaitForDeletion"
+	MethodWaitForReady    Method = "WaitForReady"
+	MethodError           Method = "Error"
+)
+
+// Call is a recorded ActionRunner call
+type Call struct {
+	// Index of the action that made the call in RunAll or Plan, -1 for direct calls
+	Index            int
+	Method           Method
+	GroupVersionKind schema.GroupVersionKind
+	// Object is a copy of the object as the runner would have sent it, with its
+	// owner reference set
+	Object     client.Object
+	Ownership  actions.Ownership
+	FieldOwner string
+	Force      bool
+	// PropagationPolicy of Recreate, Delete and DeleteAllOf
+	PropagationPolicy metav1.DeletionPropagation
+	// Selector of DeleteAllOf, whose Object holds the namespace
+	Selector string
+	// Err is the error passed to Error, or the error returned by Fail
+	Err error
+}
+
+// Key returns the namespace/name of the object of the call
+func (c Call) Key() string {
+	if c.Object == nil {
+		return ""
+	}
+	return client.ObjectKeyFromObject(c.Object).String()
+}
+
+func (c Call) String() string {
+	if c.Method == MethodError {
+		return fmt.Sprintf("#%d %s(%v)", c.Index, c.Method, c.Err)
+	}
+	return fmt.Sprintf("#%d %s %s %s", c.Index, c.Method, c.GroupVersionKind.Kind, c.Key())
+}
+
+// Runner is an actions.ActionRunner that records every call instead of changing a
+// cluster. It has no cluster state: CreateOrUpdate and CreateOrAdopt always report a
+// creation, UpdateIfChanged always updates, WaitForReady always finds the object ready
+// and WaitForDeletion always finds it gone.
+type Runner struct {
+	// Scheme resolves the kinds of typed objects and is needed to set owner references
+	Scheme *runtime.Scheme
+	// Owner, when set, becomes the controller owner of the written objects, unless the
+	// action skips the owner reference or falls back to no owner reference
+	Owner client.Object
+	// Fail, when set, is called for every call and its error is returned by the call
+	Fail func(call Call) error
+
+	lock    sync.Mutex
+	calls   []Call
+	index   int
+	running bool
+}
+
+var _ actions.ActionRunner = &Runner{}
+
+// NewRunner returns a recording runner that sets owner as controller owner
+func NewRunner(scheme *runtime.Scheme, owner client.Object) *Runner {
+	return &Runner{Scheme: scheme, Owner: owner}
+}
+
+// Calls returns the recorded calls, in order
+func (r *Runner) Calls() []Call {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	calls := make([]Call, len(r.calls))
+	copy(calls, r.calls)
+	return calls
+}
+
+// Reset forgets the recorded calls
+func (r *Runner) Reset() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.calls = nil
+}
+
+// RunAll runs the actions in order, stopping at the first error, and reports them the
+// way the ControllerActionRunner does
+func (r *Runner) RunAll(desiredState actions.DesiredResourceState) (actions.RunReport, error) {
+	report := make(actions.RunReport, len(desiredState))
+	for index, action := range desiredState {
+		report[index] = actions.ActionReport{Index: index, ID: actionName(index, action), Outcome: actions.OutcomeSkipped}
+	}
+
+	for index, action := range desiredState {
+		r.setRunning(index, true)
+		msg, err := action.Run(r)
+		r.setRunning(index, false)
+
+		entry := &report[index]
+		entry.Msg = msg
+		entry.Outcome = actions.OutcomeSuccess
+		if actions.IsResourceNotReadyError(err) {
+			entry.Outcome = actions.OutcomeWaiting
+		} else if err != nil {
+			entry.Outcome = actions.OutcomeFailed
+		}
+		if err != nil {
+			entry.Error = err.Error()
+		}
+		if call, found := r.lastCall(index); found && call.Object != nil {
+			entry.Verb = verbOf(call.Method)
+			entry.Object = r.objectReference(call.Object)
+		}
+		if err != nil {
+			return report, err
+		}
+	}
+	return report, nil
+}
+
+// Plan runs the actions like RunAll and returns their writes, without field diffs as
+// the runner does not know the live objects
+func (r *Runner) Plan(desiredState actions.DesiredResourceState, mode actions.DryRunMode) (actions.Plan, error) {
+	first := len(r.Calls())
+	report, err := r.RunAll(desiredState)
+
+	plan := actions.Plan{}
+	for _, call := range r.Calls()[first:] {
+		verb := verbOf(call.Method)
+		if verb == actions.VerbNone || call.Index < 0 {
+			continue
+		}
+		plan = append(plan, actions.PlannedAction{
+			Index:      call.Index,
+			Msg:        report[call.Index].Msg,
+			Verb:       verb,
+			APIVersion: call.GroupVersionKind.GroupVersion().String(),
+			Kind:       call.GroupVersionKind.Kind,
+			Namespace:  call.Object.GetNamespace(),
+			Name:       call.Object.GetName(),
+		})
+	}
+	return plan, err
+}
+
+func (r *Runner) Create(obj client.Object, ownership actions.Ownership) error {
+	return r.write(Call{Method: MethodCreate, Ownership: ownership}, obj)
+}
+
+func (r *Runner) Update(obj client.Object, ownership actions.Ownership) error {
+	return r.write(Call{Method: MethodUpdate, Ownership: ownership}, obj)
+}
+
+// Mutate applies mutate to obj as it was passed in, as the runner has no live object to read
+func (r *Runner) Mutate(obj client.Object, ownership actions.Ownership, mutate controllerutil.MutateFn) error {
+	err := mutate()
+	if err != nil {
+		return err
+	}
+	return r.write(Call{Method: MethodMutate, Ownership: ownership}, obj)
+}
+
+func (r *Runner) UpdateIfChanged(obj client.Object, ownership actions.Ownership) (bool, error) {
+	err := r.write(Call{Method: MethodUpdateIfChanged, Ownership: ownership}, obj)
+	return err == nil, err
+}
+
+func (r *Runner) Apply(obj client.Object, fieldOwner string, force bool, ownership actions.Ownership) error {
+	return r.write(Call{Method: MethodApply, FieldOwner: fieldOwner, Force: force, Ownership: ownership}, obj)
+}
+
+func (r *Runner) CreateOrUpdate(obj client.Object, ownership actions.Ownership) (controllerutil.OperationResult, error) {
+	err := r.write(Call{Method: MethodCreateOrUpdate, Ownership: ownership}, obj)
+	if err != nil {
+		return controllerutil.OperationResultNone, err
+	}
+	return controllerutil.OperationResultCreated, nil
+}
+
+func (r *Runner) CreateOrAdopt(obj client.Object, ownership actions.Ownership, update bool) (controllerutil.OperationResult, error) {
+	err := r.write(Call{Method: MethodCreateOrAdopt, Ownership: ownership}, obj)
+	if err != nil {
+		return controllerutil.OperationResultNone, err
+	}
+	return controllerutil.OperationResultCreated, nil
+}
+
+func (r *Runner) Recreate(obj client.Object, ownership actions.Ownership, propagation metav1.DeletionPropagation) error {
+	return r.write(Call{Method: MethodRecreate, Ownership: ownership, PropagationPolicy: propagation}, obj)
+}
+
+func (r *Runner) Delete(obj client.Object, opts ...client.DeleteOption) error {
+	options := &client.DeleteOptions{}
+	options.ApplyOptions(opts)
+	call := Call{Method: MethodDelete, Ownership: actions.Ownership{Mode: actions.OwnershipNone}}
+	if options.PropagationPolicy != nil {
+		call.PropagationPolicy = *options.PropagationPolicy
+	}
+	return r.write(call, obj)
+}
+
+func (r *Runner) DeleteAllOf(obj client.Object, opts ...client.DeleteAllOfOption) error {
+	options := &client.DeleteAllOfOptions{}
+	options.ApplyOptions(opts)
+	call := Call{Method: MethodDeleteAllOf, Ownership: actions.Ownership{Mode: actions.OwnershipNone}}
+	if options.PropagationPolicy != nil {
+		call.PropagationPolicy = *options.PropagationPolicy
+	}
+	// Like the ControllerActionRunner, refuse to delete everything
+	if options.LabelSelector == nil || options.LabelSelector.Empty() {
+		return fmt.Errorf("deleting all %v requires a label selector", r.gvk(obj).Kind)
+	}
+	call.Selector = options.LabelSelector.String()
+	sample := obj.DeepCopyObject().(client.Object)
+	sample.SetNamespace(options.Namespace)
+	return r.write(call, sample)
+}
+
+func (r *Runner) WaitForDeletion(obj client.Object) error {
+	return r.write(Call{Method: MethodWaitForDeletion, Ownership: actions.Ownership{Mode: actions.OwnershipNone}}, obj)
+}
+
+func (r *Runner) WaitForReady(obj client.Object) error {
+	return r.write(Call{Method: MethodWaitForReady, Ownership: actions.Ownership{Mode: actions.OwnershipNone}}, obj)
+}
+
+func (r *Runner) Error(err error) error {
+	call := Call{Index: r.currentIndex(), Method: MethodError, Err: err}
+	if r.Fail != nil {
+		if failure := r.Fail(call); failure != nil {
+			err = failure
+		}
+	}
+	r.add(call)
+	return err
+}
+
+// write sets the owner reference of obj and records the call
+func (r *Runner) write(call Call, obj client.Object) error {
+	call.Index = r.currentIndex()
+	call.GroupVersionKind = r.gvk(obj)
+	var err error
+	// Like the ControllerActionRunner, fall back to no owner reference where the owner cannot be one
+	fallback := call.Ownership.Fallback && r.Owner != nil && r.Owner.GetNamespace() != "" && r.Owner.GetNamespace() != obj.GetNamespace()
+	if !fallback && r.Owner != nil {
+		switch call.Ownership.Mode {
+		case actions.OwnershipOwner:
+			err = controllerutil.SetOwnerReference(r.Owner.(metav1.Object), obj.(metav1.Object), r.Scheme)
+		case actions.OwnershipNone:
+		default:
+			err = controllerutil.SetControllerReference(r.Owner.(metav1.Object), obj.(metav1.Object), r.Scheme)
+		}
+	}
+	call.Object = obj.DeepCopyObject().(client.Object)
+	if err == nil && r.Fail != nil {
+		err = r.Fail(call)
+	}
+	call.Err = err
+	r.add(call)
+	return err
+}
+
+func (r *Runner) add(call Call) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.calls = append(r.calls, call)
+}
+
+// currentIndex returns the index of the running action, or -1 outside of RunAll
+func (r *Runner) currentIndex() int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if !r.running {
+		return -1
+	}
+	return r.index
+}
+
+func (r *Runner) setRunning(index int, running bool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.index = index
+	r.running = running
+}
+
+func (r *Runner) lastCall(index int) (Call, bool) {
+	calls := r.Calls()
+	for n := len(calls) - 1; n >= 0; n-- {
+		if calls[n].Index == index {
+			return calls[n], true
+		}
+	}
+	return Call{}, false
+}
+
+func (r *Runner) gvk(obj client.Object) schema.GroupVersionKind {
+	if r.Scheme != nil {
+		if gvk, err := apiutil.GVKForObject(obj, r.Scheme); err == nil {
+			return gvk
+		}
+	}
+	return obj.GetObjectKind().GroupVersionKind()
+}
+
+func (r *Runner) objectReference(obj client.Object) *corev1.ObjectReference {
+	if r.Scheme != nil {
+		if ref, err := reference.GetReference(r.Scheme, obj); err == nil {
+			return ref
+		}
+	}
+	gvk := r.gvk(obj)
+	return &corev1.ObjectReference{
+		Kind:       gvk.Kind,
+		APIVersion: gvk.GroupVersion().String(),
+		Namespace:  obj.GetNamespace(),
+		Name:       obj.GetName(),
+		UID:        obj.GetUID(),
+	}
+}
+
+// ExpectCall returns the first call of method on the object of kind with the
+// namespace/name key, and fails t if there is none
+func (r *Runner) ExpectCall(t testing.TB, method Method, kind string, key string) Call {
+	t.Helper()
+	calls := r.Calls()
+	for _, call := range calls {
+		if call.Method == method && call.GroupVersionKind.Kind == kind && call.Key() == key {
+			return call
+		}
+	}
+	t.Errorf("expected %s of %s %s, got calls:\n%s", method, kind, key, formatCalls(calls))
+	return Call{}
+}
+
+// ExpectNoCall fails t if method was called on the object of kind with the
+// namespace/name key
+func (r *Runner) ExpectNoCall(t testing.TB, method Method, kind string, key string) {
+	t.Helper()
+	for _, call := range r.Calls() {
+		if call.Method == method && call.GroupVersionKind.Kind == kind && call.Key() == key {
+			t.Errorf("unexpected %s", call)
+		}
+	}
+}
+
+// ExpectCreate returns the first create of the object of kind with the namespace/name key
+func (r *Runner) ExpectCreate(t testing.TB, kind string, key string) Call {
+	t.Helper()
+	return r.ExpectCall(t, MethodCreate, kind, key)
+}
+
+// ExpectUpdate returns the first update of the object of kind with the namespace/name key
+func (r *Runner) ExpectUpdate(t testing.TB, kind string, key string) Call {
+	t.Helper()
+	return r.ExpectCall(t, MethodUpdate, kind, key)
+}
+
+// ExpectApply returns the first apply of the object of kind with the namespace/name key
+func (r *Runner) ExpectApply(t testing.TB, kind string, key string) Call {
+	t.Helper()
+	return r.ExpectCall(t, MethodApply, kind, key)
+}
+
+// ExpectDelete returns the first delete of the object of kind with the namespace/name key
+func (r *Runner) ExpectDelete(t testing.TB, kind string, key string) Call {
+	t.Helper()
+	return r.ExpectCall(t, MethodDelete, kind, key)
+}
+
+// ExpectError returns the first call of Error, and fails t if there is none
+func (r *Runner) ExpectError(t testing.TB) Call {
+	t.Helper()
+	calls := r.Calls()
+	for _, call := range calls {
+		if call.Method == MethodError {
+			return call
+		}
+	}
+	t.Errorf("expected Error, got calls:\n%s", formatCalls(calls))
+	return Call{}
+}
+
+// ExpectOwner fails t unless owner is the controller owner of the object of the call
+func (c Call) ExpectOwner(t testing.TB, owner client.Object) {
+	t.Helper()
+	if c.Object == nil {
+		t.Errorf("expected owner %s, but there is no object", owner.GetName())
+		return
+	}
+	controller := metav1.GetControllerOf(c.Object)
+	if controller == nil {
+		t.Errorf("expected owner %s of %s, but it has no controller", owner.GetName(), c)
+		return
+	}
+	if controller.UID != owner.GetUID() || controller.Name != owner.GetName() {
+		t.Errorf("expected owner %s of %s, got %s", owner.GetName(), c, controller.Name)
+	}
+}
+
+// ExpectOwnerReference fails t unless owner is one of the owners of the object of the
+// call, as a controller or not
+func (c Call) ExpectOwnerReference(t testing.TB, owner client.Object) {
+	t.Helper()
+	if c.Object == nil {
+		t.Errorf("expected owner reference %s, but there is no object", owner.GetName())
+		return
+	}
+	for _, ref := range c.Object.GetOwnerReferences() {
+		if ref.UID == owner.GetUID() && ref.Name == owner.GetName() {
+			return
+		}
+	}
+	t.Errorf("expected owner reference %s of %s", owner.GetName(), c)
+}
+
+// ExpectNoOwner fails t if the object of the call has a controller owner
+func (c Call) ExpectNoOwner(t testing.TB) {
+	t.Helper()
+	if c.Object == nil {
+		return
+	}
+	if controller := metav1.GetControllerOf(c.Object); controller != nil {
+		t.Errorf("expected no owner of %s, got %s", c, controller.Name)
+	}
+}
+
+func formatCalls(calls []Call) string {
+	if len(calls) == 0 {
+		return "  (none)"
+	}
+	lines := make([]string, len(calls))
+	for n, call := range calls {
+		lines[n] = "  " + call.String()
+	}
+	return strings.Join(lines, "\n")
+}
+
+func verbOf(method Method) actions.Verb {
+	switch method {
+	case MethodCreate, MethodCreateOrUpdate, MethodCreateOrAdopt:
+		return actions.VerbCreate
+	case MethodUpdate, MethodMutate, MethodUpdateIfChanged:
+		return actions.VerbUpdate
+	case MethodApply:
+		return actions.VerbApply
+	case MethodDelete:
+		return actions.VerbDelete
+	case MethodDeleteAllOf:
+		return actions.VerbDeleteCollection
+	case MethodRecreate:
+		return actions.VerbRecreate
+	}
+	return actions.VerbNone
+}
+
+// actionName returns the ID of a DependentAction or the index of any other action, as in
+// the reports of the ControllerActionRunner
+func actionName(index int, action actions.ControllerAction) string {
+	if dependent, ok := action.(actions.DependentAction); ok && dependent.ID != "" {
+		return dependent.ID
+	}
+	return fmt.Sprintf("#%d", index)
+}
@@ -0,0 +1,106 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package actionstest
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/jeesmon/operator-utils/actions"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+)
+
+// recordingT records the failures reported by the assertions under test
+type recordingT struct {
+	testing.TB
+	errors []string
+}
+
+func (t *recordingT) Helper() {}
+
+func (t *recordingT) Errorf(format string, args ...interface{}) {
+	t.errors = append(t.errors, fmt.Sprintf(format, args...))
+}
+
+func newTestRunner() (*Runner, *corev1.ConfigMap) {
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	owner := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "owner", Namespace: "test", UID: "owner-uid"}}
+	return NewRunner(scheme, owner), owner
+}
+
+func newDesiredState() actions.DesiredResourceState {
+	return actions.DesiredResourceState{
+		actions.GenericCreateAction{
+			Ref: &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "test"}},
+			Msg: "create deployment",
+		},
+		actions.GenericUpdateAction{
+			Ref:       &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "test"}},
+			Msg:       "update service",
+			Ownership: actions.OwnershipNone,
+		},
+		actions.GenericApplyAction{
+			Ref:       &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "shared", Namespace: "test"}},
+			Msg:       "apply shared config",
+			Ownership: actions.OwnershipOwner,
+		},
+		actions.GenericDeleteAction{
+			Ref: &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "old", Namespace: "test"}},
+			Msg: "delete secret",
+		},
+	}
+}
+
+func TestRunner(t *testing.T) {
+	runner, owner := newTestRunner()
+
+	report, err := runner.RunAll(newDesiredState())
+	assert.NoError(t, err)
+	assert.Len(t, report, 4)
+	assert.Equal(t, actions.VerbCreate, report[0].Verb)
+	assert.Equal(t, "Deployment", report[0].Object.Kind)
+
+	runner.ExpectCreate(t, "Deployment", "test/app").ExpectOwner(t, owner)
+	runner.ExpectUpdate(t, "Service", "test/app").ExpectNoOwner(t)
+	runner.ExpectDelete(t, "Secret", "test/old")
+	runner.ExpectApply(t, "ConfigMap", "test/shared").ExpectOwnerReference(t, owner)
+	runner.ExpectNoCall(t, MethodCreate, "Service", "test/app")
+
+	mockT := &recordingT{}
+	runner.ExpectCreate(mockT, "Deployment", "test/other")
+	assert.Len(t, mockT.errors, 1)
+	assert.Contains(t, mockT.errors[0], "expected Create of Deployment test/other")
+}
+
+func TestRunnerFail(t *testing.T) {
+	runner, _ := newTestRunner()
+	runner.Fail = func(call Call) error {
+		if call.Method == MethodUpdate {
+			return errors.New("conflict")
+		}
+		return nil
+	}
+
+	report, err := runner.RunAll(newDesiredState())
+	assert.EqualError(t, err, "conflict")
+	assert.Equal(t, actions.OutcomeFailed, report[1].Outcome)
+	assert.Equal(t, actions.OutcomeSkipped, report[3].Outcome)
+	runner.ExpectNoCall(t, MethodDelete, "Secret", "test/old")
+}
+
+func TestGoldenPlan(t *testing.T) {
+	runner, _ := newTestRunner()
+
+	plan, err := runner.Plan(newDesiredState(), actions.DryRunClient)
+	assert.NoError(t, err)
+	AssertGoldenPlan(t, plan, "testdata/plan.golden")
+}
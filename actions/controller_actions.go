@@ -7,10 +7,24 @@ package actions
 import (
 	"context"
 	"fmt"
-
-	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apiErrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/tools/reference"
+	"k8s.io/client-go/util/retry"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 )
@@ -20,11 +34,21 @@ var log = logf.Log.WithName("controlleraction")
 type DesiredResourceState []ControllerAction
 
 type ActionRunner interface {
-	RunAll(desiredState DesiredResourceState) error
-	Create(obj client.Object, skipOwnerRef bool) error
-	Update(obj client.Object, skipOwnerRef bool) error
-	Delete(obj client.Object) error
+	RunAll(desiredState DesiredResourceState) (RunReport, error)
+	Create(obj client.Object, ownership Ownership) error
+	Update(obj client.Object, ownership Ownership) error
+	Mutate(obj client.Object, ownership Ownership, mutate controllerutil.MutateFn) error
+	UpdateIfChanged(obj client.Object, ownership Ownership) (bool, error)
+	Apply(obj client.Object, fieldOwner string, force bool, ownership Ownership) error
+	CreateOrUpdate(obj client.Object, ownership Ownership) (controllerutil.OperationResult, error)
+	CreateOrAdopt(obj client.Object, ownership Ownership, update bool) (controllerutil.OperationResult, error)
+	Recreate(obj client.Object, ownership Ownership, propagation metav1.DeletionPropagation) error
+	Delete(obj client.Object, opts ...client.DeleteOption) error
+	DeleteAllOf(obj client.Object, opts ...client.DeleteAllOfOption) error
+	WaitForDeletion(obj client.Object) error
+	WaitForReady(obj client.Object) error
 	Error(err error) error
+	Plan(desiredState DesiredResourceState, mode DryRunMode) (Plan, error)
 }
 
 type ControllerAction interface {
@@ -36,6 +60,30 @@ type ControllerActionRunner struct {
 	context context.Context
 	scheme  *runtime.Scheme
 	cr      client.Object
+
+	// maximum number of actions run at the same time when actions declare dependencies
+	maxConcurrentActions int
+	runMode              RunMode
+	relatedObjects       *[]corev1.ObjectReference
+	report               *RunReport
+	recorder             record.EventRecorder
+	eventFilter          *ownerEventFilter
+	conflictRetry        wait.Backoff
+	prune                *PruneConfig
+	readiness            *ReadinessRegistry
+	// serializes the writes to the CR made while actions run
+	crLock *sync.Mutex
+
+	// index of the action being run and the last write it made, used to attribute
+	// writes to actions
+	index  int
+	verb   Verb
+	target client.Object
+	// set when the running action found its resource already in the desired state
+	unchanged bool
+	// set when the runner only records a plan instead of changing the cluster
+	dryRun DryRunMode
+	plan   *planRecorder
 }
 
 func (d *DesiredResourceState) AddAction(action ControllerAction) DesiredResourceState {
@@ -52,73 +100,578 @@ func (d *DesiredResourceState) AddActions(actions []ControllerAction) DesiredRes
 	return *d
 }
 
+// RunnerOption configures optional behaviour of a ControllerActionRunner
+type RunnerOption func(*ControllerActionRunner)
+
+// WithMaxConcurrentActions sets how many independent actions run at the same time
+// when the actions of a DesiredResourceState declare dependencies
+func WithMaxConcurrentActions(n int) RunnerOption {
+	return func(i *ControllerActionRunner) {
+		if n > 0 {
+			i.maxConcurrentActions = n
+		}
+	}
+}
+
+// WithRunMode sets what RunAll does when an action fails
+func WithRunMode(mode RunMode) RunnerOption {
+	return func(i *ControllerActionRunner) {
+		i.runMode = mode
+	}
+}
+
+// WithRelatedObjects makes RunAll add every resource it writes to objects, typically
+// the RelatedObjects of a status.CommonStatusSpec, and remove the ones it deletes
+func WithRelatedObjects(objects *[]corev1.ObjectReference) RunnerOption {
+	return func(i *ControllerActionRunner) {
+		i.relatedObjects = objects
+	}
+}
+
+// WithRunReport stores the report of every RunAll in report, so it is available to
+// callers of RunDesiredStateActions
+func WithRunReport(report *RunReport) RunnerOption {
+	return func(i *ControllerActionRunner) {
+		i.report = report
+	}
+}
+
+// WithConflictRetry sets how often and how fast Mutate and status writes are retried
+// after a conflict. wait.Backoff{Steps: 1} disables retries.
+func WithConflictRetry(backoff wait.Backoff) RunnerOption {
+	return func(i *ControllerActionRunner) {
+		if backoff.Steps > 0 {
+			i.conflictRetry = backoff
+		}
+	}
+}
+
+// WithReadinessRegistry sets the readiness checks used by WaitForReady
+func WithReadinessRegistry(registry *ReadinessRegistry) RunnerOption {
+	return func(i *ControllerActionRunner) {
+		i.readiness = registry
+	}
+}
+
 // NewControllerActionRunner creates an action runner to run kubernetes actions
-func NewControllerActionRunner(context context.Context, client client.Client, scheme *runtime.Scheme, cr client.Object) ActionRunner {
-	return &ControllerActionRunner{
-		client:  client,
-		context: context,
-		scheme:  scheme,
-		cr:      cr,
+func NewControllerActionRunner(context context.Context, client client.Client, scheme *runtime.Scheme, cr client.Object, opts ...RunnerOption) ActionRunner {
+	return newControllerActionRunner(context, client, scheme, cr, opts)
+}
+
+func newControllerActionRunner(context context.Context, client client.Client, scheme *runtime.Scheme, cr client.Object, opts []RunnerOption) *ControllerActionRunner {
+	runner := &ControllerActionRunner{
+		client:               client,
+		context:              context,
+		scheme:               scheme,
+		cr:                   cr,
+		maxConcurrentActions: DefaultMaxConcurrentActions,
+		runMode:              RunModeStopOnError,
+		conflictRetry:        DefaultConflictRetry,
+		crLock:               &sync.Mutex{},
+		readiness:            DefaultReadinessRegistry,
+	}
+	for _, opt := range opts {
+		opt(runner)
+	}
+	return runner
+}
+
+// RunAll runs the actions in order. When some of the actions are DependentActions, they
+// are run as a dependency graph instead. In RunModeStopOnError the first failure stops
+// the run, in RunModeContinueOnError all failures are returned as an AggregateActionError.
+// The report holds an entry for every action, including the ones that were skipped.
+func (i *ControllerActionRunner) RunAll(desiredState DesiredResourceState) (RunReport, error) {
+	report := newRunReport(desiredState)
+
+	var err error
+	if hasDependencies(desiredState) {
+		err = i.runGraph(desiredState, report)
+	} else {
+		err = i.runSequence(desiredState, report)
 	}
+
+	// Only prune after a complete run, as a failed action may not have written its resource
+	if err == nil && i.prune != nil {
+		report, err = i.pruneResources(report)
+	}
+
+	if i.report != nil {
+		*i.report = report
+	}
+	return report, err
 }
 
-func (i *ControllerActionRunner) RunAll(desiredState DesiredResourceState) error {
+func (i *ControllerActionRunner) runSequence(desiredState DesiredResourceState, report RunReport) error {
+	var failures []*ActionError
 	for index, action := range desiredState {
-		msg, err := action.Run(i)
+		result := i.runAction(index, action)
+		i.record(report, result)
+		if result.err == nil {
+			continue
+		}
+		if i.runMode != RunModeContinueOnError {
+			return result.err
+		}
+		failures = append(failures, i.actionError(result, actionName(index, action), nil))
+	}
+
+	return newAggregateActionError(failures)
+}
+
+func (i *ControllerActionRunner) runAction(index int, action ControllerAction) actionResult {
+	runner := i.forAction(index)
+	started := time.Now()
+	msg, err := action.Run(runner)
+	if i.plan != nil {
+		i.plan.describe(index, msg)
+	}
+
+	result := actionResult{
+		index:    index,
+		msg:      msg,
+		err:      err,
+		verb:     runner.verb,
+		target:   runner.target,
+		duration: time.Since(started),
+		outcome:  OutcomeSuccess,
+	}
+	if IsResourceNotReadyError(err) {
+		result.outcome = OutcomeWaiting
+	} else if err != nil {
+		result.outcome = OutcomeFailed
+	} else if runner.unchanged {
+		result.outcome = OutcomeUnchanged
+	} else if i.dryRun != "" {
+		result.outcome = OutcomePlanned
+	} else if runner.verb == VerbRecreate {
+		result.outcome = OutcomeRecreated
+	}
+
+	log.Info(fmt.Sprintf("(%5d) %10s %s", index, result.outcome, msg))
+	return result
+}
+
+func (i *ControllerActionRunner) actionError(result actionResult, name string, skipped []string) *ActionError {
+	actionErr := &ActionError{
+		Index:   result.index,
+		ID:      name,
+		Msg:     result.msg,
+		Err:     result.err,
+		Skipped: skipped,
+	}
+	if result.target != nil {
+		actionErr.Object = i.objectReference(result.target)
+	}
+	return actionErr
+}
+
+// objectReference returns a reference to obj, falling back to its name if the kind
+// is not known to the scheme
+func (i *ControllerActionRunner) objectReference(obj client.Object) *corev1.ObjectReference {
+	ref, err := reference.GetReference(i.scheme, obj)
+	if err != nil {
+		return &corev1.ObjectReference{
+			Namespace: obj.GetNamespace(),
+			Name:      obj.GetName(),
+			UID:       obj.GetUID(),
+		}
+	}
+	return ref
+}
+
+// forAction returns a copy of the runner that attributes its writes to the action at index
+func (i *ControllerActionRunner) forAction(index int) *ControllerActionRunner {
+	runner := *i
+	runner.index = index
+	return &runner
+}
+
+// track remembers the write the running action makes
+func (i *ControllerActionRunner) track(verb Verb, obj client.Object) {
+	i.verb = verb
+	i.target = obj
+}
+
+// trackUnchanged remembers that the running action skipped the write of obj because it
+// is already in the desired state
+func (i *ControllerActionRunner) trackUnchanged(obj client.Object) {
+	i.track(VerbNone, obj)
+	i.unchanged = true
+	if i.dryRun != "" {
+		i.planUnchanged(obj)
+	}
+}
+
+func (i *ControllerActionRunner) Create(obj client.Object, ownership Ownership) error {
+	i.track(VerbCreate, obj)
+	err := i.setOwner(obj, ownership)
+	if err != nil {
+		return err
+	}
+
+	if i.dryRun != "" {
+		return i.planWrite(VerbCreate, obj, func(obj client.Object) error {
+			return i.client.Create(i.context, obj, client.DryRunAll)
+		})
+	}
+
+	err = i.client.Create(i.context, obj)
+	if err != nil {
+		log.Error(err, "Error creating object")
+		return err
+	}
+
+	return nil
+}
+
+// Update replaces obj. An obj without resourceVersion is a desired state built from
+// scratch, so the owner references of the live object are merged into it first. An obj
+// read before a concurrent write fails with a conflict, as writing it would undo that
+// write; use Mutate to retry such changes on the latest version.
+func (i *ControllerActionRunner) Update(obj client.Object, ownership Ownership) error {
+	i.track(VerbUpdate, obj)
+	if obj.GetResourceVersion() == "" {
+		err := i.mergeLiveOwnerReferences(obj)
 		if err != nil {
-			log.Info(fmt.Sprintf("(%5d) %10s %s", index, "FAILED", msg))
 			return err
 		}
-		log.Info(fmt.Sprintf("(%5d) %10s %s", index, "SUCCESS", msg))
+	}
+	err := i.setOwner(obj, ownership)
+	if err != nil {
+		return err
+	}
+
+	if i.dryRun != "" {
+		return i.planWrite(VerbUpdate, obj, func(obj client.Object) error {
+			return i.client.Update(i.context, obj, client.DryRunAll)
+		})
+	}
+
+	err = i.client.Update(i.context, obj)
+	if err != nil {
+		log.Error(err, "Error updating object")
+		return err
 	}
 
 	return nil
 }
 
-func (i *ControllerActionRunner) Create(obj client.Object, skipOwnerRef bool) error {
-	owner := i.cr.(v1.Object)
-	resource := obj.(v1.Object)
+// Mutate reads obj, applies mutate to it and updates it. On a conflict, obj is read again
+// and mutate applied to the latest version, so the changes of other writers are kept.
+func (i *ControllerActionRunner) Mutate(obj client.Object, ownership Ownership, mutate controllerutil.MutateFn) error {
+	i.track(VerbUpdate, obj)
+	key := client.ObjectKeyFromObject(obj)
 
-	if !skipOwnerRef {
-		err := controllerutil.SetControllerReference(owner, resource, i.scheme)
+	err := retry.RetryOnConflict(i.conflictRetry, func() error {
+		err := i.client.Get(i.context, key, obj)
+		if err != nil {
+			return err
+		}
+		err = mutate()
+		if err != nil {
+			return err
+		}
+		err = i.setOwner(obj, ownership)
 		if err != nil {
-			log.Error(err, "Error setting controller reference")
 			return err
 		}
+
+		if i.dryRun != "" {
+			return i.planWrite(VerbUpdate, obj, func(obj client.Object) error {
+				return i.client.Update(i.context, obj, client.DryRunAll)
+			})
+		}
+		return i.client.Update(i.context, obj)
+	})
+	if err != nil {
+		log.Error(err, "Error updating object")
+		return err
+	}
+
+	return nil
+}
+
+// UpdateIfChanged stamps obj with a hash of its desired state and updates it only when
+// the hash differs from the one on the live object. Changes made to the live object by
+// others are not detected, as long as they leave the hash annotation alone.
+func (i *ControllerActionRunner) UpdateIfChanged(obj client.Object, ownership Ownership) (bool, error) {
+	i.track(VerbUpdate, obj)
+	live := obj.DeepCopyObject().(client.Object)
+	err := i.client.Get(i.context, client.ObjectKeyFromObject(obj), live)
+	if err != nil {
+		log.Error(err, "Error reading object")
+		return false, err
 	}
 
-	err := i.client.Create(i.context, obj)
+	// The owner references are part of the desired state and the inventory label is written
+	// along with them, so set them before hashing
+	mergeOwnerReferences(obj, live)
+	err = i.setOwner(obj, ownership)
 	if err != nil {
-		log.Error(err, "Error creating object")
+		return false, err
+	}
+
+	hash, err := specHash(obj)
+	if err != nil {
+		log.Error(err, "Error hashing object")
+		return false, err
+	}
+	annotations := obj.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[SpecHashAnnotation] = hash
+	obj.SetAnnotations(annotations)
+
+	if live.GetAnnotations()[SpecHashAnnotation] == hash {
+		i.trackUnchanged(obj)
+		return false, nil
+	}
+
+	obj.SetResourceVersion(live.GetResourceVersion())
+	err = i.Update(obj, ownership)
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Apply sends obj as a server-side apply patch owned by fieldOwner, so only the fields
+// set on obj are managed by this operator and fields owned by other managers are kept
+func (i *ControllerActionRunner) Apply(obj client.Object, fieldOwner string, force bool, ownership Ownership) error {
+	i.track(VerbApply, obj)
+	err := i.setOwner(obj, ownership)
+	if err != nil {
+		return err
+	}
+
+	// Apply patches are sent as-is, so the type information must be present on the object
+	gvk, err := apiutil.GVKForObject(obj, i.scheme)
+	if err != nil {
+		log.Error(err, "Error resolving object kind")
+		return err
+	}
+	obj.GetObjectKind().SetGroupVersionKind(gvk)
+	obj.SetManagedFields(nil)
+
+	opts := []client.PatchOption{client.FieldOwner(fieldOwner)}
+	if force {
+		opts = append(opts, client.ForceOwnership)
+	}
+
+	if i.dryRun != "" {
+		return i.planWrite(VerbApply, obj, func(obj client.Object) error {
+			return i.client.Patch(i.context, obj, client.Apply, append(opts, client.DryRunAll)...)
+		})
+	}
+
+	err = i.client.Patch(i.context, obj, client.Apply, opts...)
+	if err != nil {
+		if apiErrors.IsConflict(err) {
+			err = &ApplyConflictError{PartialObject: obj, FieldOwner: fieldOwner, Err: err}
+		}
+		log.Error(err, "Error applying object")
 		return err
 	}
 
 	return nil
 }
 
-func (i *ControllerActionRunner) Update(obj client.Object, skipOwnerRef bool) error {
-	owner := i.cr.(v1.Object)
-	resource := obj.(v1.Object)
+// CreateOrUpdate creates obj if it does not exist yet and updates it only when one of the
+// fields set on obj differs from the live object
+func (i *ControllerActionRunner) CreateOrUpdate(obj client.Object, ownership Ownership) (controllerutil.OperationResult, error) {
+	i.track(VerbNone, obj)
+	live := obj.DeepCopyObject().(client.Object)
+	err := i.client.Get(i.context, client.ObjectKeyFromObject(obj), live)
+	if apiErrors.IsNotFound(err) {
+		err = i.Create(obj, ownership)
+		if err != nil {
+			return controllerutil.OperationResultNone, err
+		}
+		return controllerutil.OperationResultCreated, nil
+	}
+	if err != nil {
+		log.Error(err, "Error reading object")
+		return controllerutil.OperationResultNone, err
+	}
+
+	// The owner references are part of the desired state and the inventory label is written
+	// along with them, so set them before comparing
+	mergeOwnerReferences(obj, live)
+	err = i.setOwner(obj, ownership)
+	if err != nil {
+		return controllerutil.OperationResultNone, err
+	}
+
+	upToDate, err := isUpToDate(obj, live)
+	if err != nil {
+		log.Error(err, "Error comparing object")
+		return controllerutil.OperationResultNone, err
+	}
+	if upToDate {
+		i.trackUnchanged(obj)
+		return controllerutil.OperationResultNone, nil
+	}
 
-	if !skipOwnerRef {
-		err := controllerutil.SetControllerReference(owner, resource, i.scheme)
+	obj.SetResourceVersion(live.GetResourceVersion())
+	err = i.Update(obj, ownership)
+	if err != nil {
+		return controllerutil.OperationResultNone, err
+	}
+
+	return controllerutil.OperationResultUpdated, nil
+}
+
+// CreateOrAdopt creates obj and, if it already exists, adopts the existing object when it
+// has no controller or is controlled by the CR: the owner reference and inventory label
+// of the CR are patched onto it, leaving the rest of it alone. An adopted object is only
+// updated to obj when update is set and it drifted from obj. Objects controlled by
+// another owner are reported as an OwnershipConflictError.
+func (i *ControllerActionRunner) CreateOrAdopt(obj client.Object, ownership Ownership, update bool) (controllerutil.OperationResult, error) {
+	// Plans cannot try the create, so they check whether the object exists first
+	if i.dryRun == "" {
+		i.track(VerbCreate, obj)
+		err := i.setOwner(obj, ownership)
 		if err != nil {
-			log.Error(err, "Error setting controller reference")
-			return err
+			return controllerutil.OperationResultNone, err
+		}
+		err = i.client.Create(i.context, obj)
+		if err == nil {
+			return controllerutil.OperationResultCreated, nil
 		}
+		if !apiErrors.IsAlreadyExists(err) {
+			log.Error(err, "Error creating object")
+			return controllerutil.OperationResultNone, err
+		}
+	}
+
+	live := obj.DeepCopyObject().(client.Object)
+	err := i.client.Get(i.context, client.ObjectKeyFromObject(obj), live)
+	if apiErrors.IsNotFound(err) && i.dryRun != "" {
+		return controllerutil.OperationResultCreated, i.Create(obj, ownership)
+	}
+	if err != nil {
+		log.Error(err, "Error reading object")
+		return controllerutil.OperationResultNone, err
+	}
+
+	if controller := metav1.GetControllerOf(live); controller != nil && controller.UID != i.cr.GetUID() {
+		err = &OwnershipConflictError{PartialObject: live, Owner: *controller}
+		log.Error(err, "Error adopting object")
+		return controllerutil.OperationResultNone, err
 	}
 
-	err := i.client.Update(i.context, obj)
+	if update {
+		return i.CreateOrUpdate(obj, ownership)
+	}
+	return i.adopt(live, ownership)
+}
+
+// adopt sets the owner reference and inventory label of the CR on live with a patch of
+// its metadata
+func (i *ControllerActionRunner) adopt(live client.Object, ownership Ownership) (controllerutil.OperationResult, error) {
+	adopted := live.DeepCopyObject().(client.Object)
+	err := i.setOwner(adopted, ownership)
+	if err != nil {
+		return controllerutil.OperationResultNone, err
+	}
+	if equality.Semantic.DeepEqual(adopted.GetOwnerReferences(), live.GetOwnerReferences()) &&
+		equality.Semantic.DeepEqual(adopted.GetLabels(), live.GetLabels()) &&
+		equality.Semantic.DeepEqual(adopted.GetAnnotations(), live.GetAnnotations()) {
+		i.trackUnchanged(adopted)
+		return controllerutil.OperationResultNone, nil
+	}
+
+	i.track(VerbUpdate, adopted)
+	patch := client.MergeFromWithOptions(live, client.MergeFromWithOptimisticLock{})
+	if i.dryRun != "" {
+		return controllerutil.OperationResultUpdated, i.planWrite(VerbUpdate, adopted, func(planned client.Object) error {
+			return i.client.Patch(i.context, planned, patch, client.DryRunAll)
+		})
+	}
+
+	err = i.client.Patch(i.context, adopted, patch)
+	if err != nil {
+		log.Error(err, "Error adopting object")
+		return controllerutil.OperationResultNone, err
+	}
+
+	return controllerutil.OperationResultUpdated, nil
+}
+
+// Recreate deletes the live version of obj with the propagation policy, Background when
+// it is empty, and creates obj, for updates that change immutable fields. It returns a
+// ResourceNotReadyError while the live object is still being deleted.
+func (i *ControllerActionRunner) Recreate(obj client.Object, ownership Ownership, propagation metav1.DeletionPropagation) error {
+	i.track(VerbRecreate, obj)
+	err := i.setOwner(obj, ownership)
 	if err != nil {
-		log.Error(err, "Error updating object")
+		return err
+	}
+
+	if i.dryRun != "" {
+		// Neither the delete nor the create can be dry-run, as the create depends on the delete
+		return i.planWrite(VerbRecreate, obj, func(obj client.Object) error {
+			return nil
+		})
+	}
+
+	live := obj.DeepCopyObject().(client.Object)
+	err = i.client.Get(i.context, client.ObjectKeyFromObject(obj), live)
+	if err == nil {
+		if live.GetDeletionTimestamp() != nil {
+			return &ResourceNotReadyError{PartialObject: obj}
+		}
+		// The default policy of some kinds, like Jobs, orphans the dependents, which would
+		// keep running next to the new resource
+		if propagation == "" {
+			propagation = metav1.DeletePropagationBackground
+		}
+		// The precondition keeps a resource that was recreated in the meantime
+		uid := live.GetUID()
+		err = i.client.Delete(i.context, live, client.Preconditions{UID: &uid}, client.PropagationPolicy(propagation))
+	}
+	if err != nil && !apiErrors.IsNotFound(err) {
+		log.Error(err, "Error deleting object")
+		return err
+	}
+
+	// obj may be a copy of the live object, which cannot be created as-is
+	obj.SetResourceVersion("")
+	obj.SetUID("")
+	obj.SetCreationTimestamp(metav1.Time{})
+	obj.SetDeletionTimestamp(nil)
+	obj.SetManagedFields(nil)
+	err = i.client.Create(i.context, obj)
+	if apiErrors.IsAlreadyExists(err) {
+		// Foreground deletions and finalizers keep the old object around for a while
+		return &ResourceNotReadyError{PartialObject: obj}
+	}
+	if err != nil {
+		log.Error(err, "Error creating object")
 		return err
 	}
 
 	return nil
 }
 
-func (i *ControllerActionRunner) Delete(obj client.Object) error {
-	err := i.client.Delete(i.context, obj)
+// Delete deletes obj. An obj that is already gone is reported as unchanged, with the
+// NotFound error left for the caller to tolerate or not.
+func (i *ControllerActionRunner) Delete(obj client.Object, opts ...client.DeleteOption) error {
+	i.track(VerbDelete, obj)
+	if i.dryRun != "" {
+		return i.planWrite(VerbDelete, obj, func(obj client.Object) error {
+			return i.client.Delete(i.context, obj, append(opts, client.DryRunAll)...)
+		})
+	}
+
+	err := i.client.Delete(i.context, obj, opts...)
+	if apiErrors.IsNotFound(err) {
+		// The verb is kept, so obj is still removed from the related objects
+		i.unchanged = true
+		return err
+	}
 	if err != nil {
 		log.Error(err, "Error deleting object")
 		return err
@@ -127,6 +680,109 @@ func (i *ControllerActionRunner) Delete(obj client.Object) error {
 	return nil
 }
 
+// DeleteAllOf deletes every object of the kind of obj matching opts, which must hold a
+// label selector and, for namespaced kinds, a namespace. Kinds unknown to the RESTMapper
+// of the client are expected to be namespaced, so a mistake cannot delete across
+// namespaces.
+func (i *ControllerActionRunner) DeleteAllOf(obj client.Object, opts ...client.DeleteAllOfOption) error {
+	i.track(VerbDeleteCollection, obj)
+	gvk, err := apiutil.GVKForObject(obj, i.scheme)
+	if err != nil {
+		log.Error(err, "Error resolving object kind")
+		return err
+	}
+	options := &client.DeleteAllOfOptions{}
+	options.ApplyOptions(opts)
+	if options.LabelSelector == nil || options.LabelSelector.Empty() {
+		return errors.Errorf("deleting all %v requires a label selector", gvk.Kind)
+	}
+	if options.Namespace == "" && i.isNamespaced(gvk) {
+		return errors.Errorf("deleting all %v requires a namespace", gvk.Kind)
+	}
+
+	if i.dryRun != "" {
+		return i.planDeleteAllOf(obj, opts)
+	}
+
+	err = i.client.DeleteAllOf(i.context, obj, opts...)
+	if err != nil {
+		log.Error(err, "Error deleting objects")
+		return err
+	}
+
+	return nil
+}
+
+func (i *ControllerActionRunner) isNamespaced(gvk schema.GroupVersionKind) bool {
+	mapping, err := i.client.RESTMapper().RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		return true
+	}
+	return mapping.Scope.Name() == meta.RESTScopeNameNamespace
+}
+
+// WaitForDeletion returns a ResourceNotReadyError until obj no longer exists. Plans do
+// not wait, as the deletions they depend on are not made.
+func (i *ControllerActionRunner) WaitForDeletion(obj client.Object) error {
+	if i.dryRun != "" {
+		return nil
+	}
+
+	gvk, err := apiutil.GVKForObject(obj, i.scheme)
+	if err != nil {
+		log.Error(err, "Error resolving object kind")
+		return err
+	}
+
+	live := obj.DeepCopyObject().(client.Object)
+	err = i.client.Get(i.context, client.ObjectKeyFromObject(obj), live)
+	if apiErrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		log.Error(err, "Error reading object")
+		return err
+	}
+	live.GetObjectKind().SetGroupVersionKind(gvk)
+	return &ResourceNotReadyError{PartialObject: live, Deleting: true}
+}
+
+// WaitForReady reads obj and returns a ResourceNotReadyError until it exists and is
+// ready. Plans do not wait, as the resources they depend on are not written.
+func (i *ControllerActionRunner) WaitForReady(obj client.Object) error {
+	i.track(VerbNone, obj)
+	if i.dryRun != "" {
+		return nil
+	}
+
+	gvk, err := apiutil.GVKForObject(obj, i.scheme)
+	if err != nil {
+		log.Error(err, "Error resolving object kind")
+		return err
+	}
+
+	err = i.client.Get(i.context, client.ObjectKeyFromObject(obj), obj)
+	// The kind is cleared when reading typed objects, but names the resource in errors
+	obj.GetObjectKind().SetGroupVersionKind(gvk)
+	if apiErrors.IsNotFound(err) {
+		return &ResourceNotReadyError{PartialObject: obj}
+	}
+	if err != nil {
+		log.Error(err, "Error reading object")
+		return err
+	}
+
+	ready, err := i.readiness.IsReady(i.context, i.client, obj)
+	if err != nil {
+		return err
+	}
+	if !ready {
+		return &ResourceNotReadyError{PartialObject: obj}
+	}
+
+	return nil
+}
+
 func (i *ControllerActionRunner) Error(err error) error {
 	return err
 }
@@ -134,22 +790,98 @@ func (i *ControllerActionRunner) Error(err error) error {
 // An action to create generic kubernetes resources
 // (resources that don't require special treatment)
 type GenericCreateAction struct {
-	Ref          client.Object
-	Msg          string
-	SkipOwnerRef bool
+	Ref           client.Object
+	Msg           string
+	Ownership     OwnershipMode
+	OwnerFallback bool
+	// AdoptExisting accepts a resource that already exists when it has no controller or
+	// is controlled by the CR, instead of failing with AlreadyExists, and sets the CR as
+	// its owner
+	AdoptExisting bool
+	// UpdateAdopted updates an adopted resource that drifted from Ref
+	UpdateAdopted bool
 }
 
 // An action to update generic kubernetes resources
 // (resources that don't require special treatment)
 type GenericUpdateAction struct {
-	Ref          client.Object
-	Msg          string
-	SkipOwnerRef bool
+	Ref           client.Object
+	Msg           string
+	Ownership     OwnershipMode
+	OwnerFallback bool
+	// SkipUnchanged skips the update when the spec hash annotation of the live object
+	// matches the desired object
+	SkipUnchanged bool
+	// RecreateOnImmutable deletes the resource with PropagationPolicy, Background by
+	// default, and creates it again when the update is rejected because it changes
+	// immutable fields
+	RecreateOnImmutable bool
+	PropagationPolicy   metav1.DeletionPropagation
+	// Mutate, when set, changes Ref after it is read, and is applied again to the latest
+	// version of Ref on conflicts. SkipUnchanged does not apply to it.
+	Mutate controllerutil.MutateFn
+}
+
+// An action to server-side apply generic kubernetes resources
+// (resources that don't require special treatment)
+type GenericApplyAction struct {
+	Ref           client.Object
+	Msg           string
+	FieldOwner    string
+	Force         bool
+	Ownership     OwnershipMode
+	OwnerFallback bool
+}
+
+// An action to create generic kubernetes resources or update them when they drifted
+// from the desired state (resources that don't require special treatment)
+type GenericReconcileAction struct {
+	Ref           client.Object
+	Msg           string
+	Ownership     OwnershipMode
+	OwnerFallback bool
 }
 
 // An action to delete generic kubernetes resources
 // (resources that don't require special treatment)
 type GenericDeleteAction struct {
+	Ref               client.Object
+	Msg               string
+	PropagationPolicy metav1.DeletionPropagation
+	// IgnoreNotFound treats a resource that is already gone as deleted
+	IgnoreNotFound bool
+	// WaitForDeletion returns a ResourceNotReadyError until the resource is gone, so the
+	// actions after it only run once finalizers and foreground deletion completed
+	WaitForDeletion bool
+}
+
+// An action to delete all resources of the kind of Ref in Namespace matching Selector.
+// Selector must not be empty, and Namespace is required for namespaced kinds.
+type DeleteAllOfAction struct {
+	Ref               client.Object
+	Namespace         string
+	Selector          labels.Selector
+	Msg               string
+	PropagationPolicy metav1.DeletionPropagation
+}
+
+// An action to add a finalizer to a resource, typically the CR itself
+type AddFinalizerAction struct {
+	Ref       client.Object
+	Finalizer string
+	Msg       string
+}
+
+// An action to remove a finalizer from a resource, typically the CR itself
+type RemoveFinalizerAction struct {
+	Ref       client.Object
+	Finalizer string
+	Msg       string
+}
+
+// An action that returns a ResourceNotReadyError until a resource is ready, so the
+// actions after it only run in a later reconcile
+type WaitForReadyAction struct {
 	Ref client.Object
 	Msg string
 }
@@ -161,15 +893,109 @@ type GenericErrorAction struct {
 }
 
 func (i GenericCreateAction) Run(runner ActionRunner) (string, error) {
-	return i.Msg, runner.Create(i.Ref, i.SkipOwnerRef)
+	ownership := Ownership{Mode: i.Ownership, Fallback: i.OwnerFallback}
+	if i.AdoptExisting {
+		_, err := runner.CreateOrAdopt(i.Ref, ownership, i.UpdateAdopted)
+		return i.Msg, err
+	}
+	return i.Msg, runner.Create(i.Ref, ownership)
 }
 
 func (i GenericDeleteAction) Run(runner ActionRunner) (string, error) {
-	return i.Msg, runner.Delete(i.Ref)
+	var opts []client.DeleteOption
+	if i.PropagationPolicy != "" {
+		opts = append(opts, client.PropagationPolicy(i.PropagationPolicy))
+	}
+	err := runner.Delete(i.Ref, opts...)
+	if apiErrors.IsNotFound(err) && (i.IgnoreNotFound || i.WaitForDeletion) {
+		return i.Msg, nil
+	}
+	if err != nil || !i.WaitForDeletion {
+		return i.Msg, err
+	}
+	return i.Msg, runner.WaitForDeletion(i.Ref)
+}
+
+func (i DeleteAllOfAction) Run(runner ActionRunner) (string, error) {
+	var opts []client.DeleteAllOfOption
+	if i.Namespace != "" {
+		opts = append(opts, client.InNamespace(i.Namespace))
+	}
+	if i.Selector != nil {
+		opts = append(opts, client.MatchingLabelsSelector{Selector: i.Selector})
+	}
+	if i.PropagationPolicy != "" {
+		opts = append(opts, client.PropagationPolicy(i.PropagationPolicy))
+	}
+	return i.Msg, runner.DeleteAllOf(i.Ref, opts...)
 }
 
 func (i GenericUpdateAction) Run(runner ActionRunner) (string, error) {
-	return i.Msg, runner.Update(i.Ref, i.SkipOwnerRef)
+	ownership := Ownership{Mode: i.Ownership, Fallback: i.OwnerFallback}
+	var err error
+	if i.Mutate != nil {
+		err = runner.Mutate(i.Ref, ownership, i.Mutate)
+	} else if i.SkipUnchanged {
+		_, err = runner.UpdateIfChanged(i.Ref, ownership)
+	} else {
+		err = runner.Update(i.Ref, ownership)
+	}
+	// A resource that is missing was deleted by an earlier recreate that had to wait
+	if i.RecreateOnImmutable && (IsImmutableFieldError(err) || apiErrors.IsNotFound(err)) {
+		err = runner.Recreate(i.Ref, ownership, i.PropagationPolicy)
+	}
+	return i.Msg, err
+}
+
+func (i GenericApplyAction) Run(runner ActionRunner) (string, error) {
+	return i.Msg, runner.Apply(i.Ref, i.FieldOwner, i.Force, Ownership{Mode: i.Ownership, Fallback: i.OwnerFallback})
+}
+
+func (i GenericReconcileAction) Run(runner ActionRunner) (string, error) {
+	result, err := runner.CreateOrUpdate(i.Ref, Ownership{Mode: i.Ownership, Fallback: i.OwnerFallback})
+	if err != nil {
+		return i.Msg, err
+	}
+	return fmt.Sprintf("%s (%s)", i.Msg, result), nil
+}
+
+func (i AddFinalizerAction) Run(runner ActionRunner) (string, error) {
+	if controllerutil.ContainsFinalizer(i.Ref, i.Finalizer) {
+		return i.Msg, nil
+	}
+	return i.Msg, mutateFinalizers(runner, i.Ref, func(obj client.Object) {
+		controllerutil.AddFinalizer(obj, i.Finalizer)
+	})
+}
+
+func (i RemoveFinalizerAction) Run(runner ActionRunner) (string, error) {
+	if !controllerutil.ContainsFinalizer(i.Ref, i.Finalizer) {
+		return i.Msg, nil
+	}
+	return i.Msg, mutateFinalizers(runner, i.Ref, func(obj client.Object) {
+		controllerutil.RemoveFinalizer(obj, i.Finalizer)
+	})
+}
+
+// mutateFinalizers changes only the finalizers of the latest version of ref, so the
+// changes of other writers to ref are kept. The pending changes of the caller to ref are
+// kept too, as only its finalizers and resourceVersion are updated.
+func mutateFinalizers(runner ActionRunner, ref client.Object, mutate func(obj client.Object)) error {
+	latest := ref.DeepCopyObject().(client.Object)
+	err := runner.Mutate(latest, Ownership{Mode: OwnershipNone}, func() error {
+		mutate(latest)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	ref.SetFinalizers(latest.GetFinalizers())
+	ref.SetResourceVersion(latest.GetResourceVersion())
+	return nil
+}
+
+func (i WaitForReadyAction) Run(runner ActionRunner) (string, error) {
+	return i.Msg, runner.WaitForReady(i.Ref)
 }
 
 func (i GenericErrorAction) Run(runner ActionRunner) (string, error) {
@@ -0,0 +1,642 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package actions
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apiErrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// patchClientMock records apply patches, which the fake client does not support
+type patchClientMock struct {
+	client.Client
+	patchOptions *client.PatchOptions
+	patchErr     error
+}
+
+func (c *patchClientMock) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	c.patchOptions = &client.PatchOptions{}
+	c.patchOptions.ApplyOptions(opts)
+	return c.patchErr
+}
+
+// immutableClientMock rejects every update, like an update changing an immutable field,
+// and records the options of the last delete
+type immutableClientMock struct {
+	client.Client
+	deleteOptions *client.DeleteOptions
+}
+
+func (c *immutableClientMock) Delete(ctx context.Context, obj client.Object, opts ...client.DeleteOption) error {
+	c.deleteOptions = &client.DeleteOptions{}
+	c.deleteOptions.ApplyOptions(opts)
+	return c.Client.Delete(ctx, obj, opts...)
+}
+
+func (c *immutableClientMock) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	return apiErrors.NewInvalid(schema.GroupKind{Group: "batch", Kind: "Job"}, obj.GetName(), field.ErrorList{
+		field.Invalid(field.NewPath("spec", "template"), nil, "field is immutable"),
+	})
+}
+
+// concurrentWriteClientMock runs write before the first update, like another writer
+// changing the object between the read and the update of the runner
+type concurrentWriteClientMock struct {
+	client.Client
+	write func(c client.Client, obj client.Object) error
+}
+
+func (c *concurrentWriteClientMock) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	if c.write != nil {
+		write := c.write
+		c.write = nil
+		if err := write(c.Client, obj); err != nil {
+			return err
+		}
+	}
+	return c.Client.Update(ctx, obj, opts...)
+}
+
+func newTestScheme() *runtime.Scheme {
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	return scheme
+}
+
+func newTestOwner() *corev1.ConfigMap {
+	return &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "owner",
+			Namespace: "test",
+			UID:       "owner-uid",
+		},
+	}
+}
+
+func TestApplyAction(t *testing.T) {
+	scheme := newTestScheme()
+	owner := newTestOwner()
+	cl := &patchClientMock{Client: fake.NewClientBuilder().WithScheme(scheme).Build()}
+	runner := NewControllerActionRunner(context.TODO(), cl, scheme, owner)
+
+	secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "secret", Namespace: "test"}}
+	msg, err := GenericApplyAction{Ref: secret, Msg: "apply secret", FieldOwner: "operator", Force: true}.Run(runner)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "apply secret", msg)
+	assert.Equal(t, "Secret", secret.GetObjectKind().GroupVersionKind().Kind)
+	assert.Equal(t, "operator", cl.patchOptions.FieldManager)
+	assert.True(t, *cl.patchOptions.Force)
+	assert.Len(t, secret.GetOwnerReferences(), 1)
+	assert.Equal(t, owner.GetUID(), secret.GetOwnerReferences()[0].UID)
+}
+
+func TestApplyActionConflict(t *testing.T) {
+	scheme := newTestScheme()
+	cl := &patchClientMock{
+		Client:   fake.NewClientBuilder().WithScheme(scheme).Build(),
+		patchErr: apiErrors.NewConflict(schema.GroupResource{Resource: "secrets"}, "secret", nil),
+	}
+	runner := NewControllerActionRunner(context.TODO(), cl, scheme, newTestOwner())
+
+	secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "secret", Namespace: "test"}}
+	_, err := GenericApplyAction{Ref: secret, FieldOwner: "operator", Ownership: OwnershipNone}.Run(runner)
+
+	assert.True(t, IsApplyConflictError(err))
+	assert.True(t, apiErrors.IsConflict(err))
+	assert.Nil(t, cl.patchOptions.Force)
+	assert.Empty(t, secret.GetOwnerReferences())
+}
+
+func TestReconcileAction(t *testing.T) {
+	scheme := newTestScheme()
+	cl := fake.NewClientBuilder().WithScheme(scheme).Build()
+	runner := NewControllerActionRunner(context.TODO(), cl, scheme, newTestOwner())
+
+	newConfigMap := func(value string) *corev1.ConfigMap {
+		return &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "config", Namespace: "test"},
+			Data:       map[string]string{"key": value},
+		}
+	}
+
+	msg, err := GenericReconcileAction{Ref: newConfigMap("a"), Msg: "config"}.Run(runner)
+	assert.NoError(t, err)
+	assert.Equal(t, "config (created)", msg)
+
+	// Fields set by someone else are not part of the desired state
+	live := &corev1.ConfigMap{}
+	assert.NoError(t, cl.Get(context.TODO(), client.ObjectKey{Namespace: "test", Name: "config"}, live))
+	live.Labels["injected"] = "true"
+	assert.NoError(t, cl.Update(context.TODO(), live))
+
+	msg, err = GenericReconcileAction{Ref: newConfigMap("a"), Msg: "config"}.Run(runner)
+	assert.NoError(t, err)
+	assert.Equal(t, "config (unchanged)", msg)
+
+	msg, err = GenericReconcileAction{Ref: newConfigMap("b"), Msg: "config"}.Run(runner)
+	assert.NoError(t, err)
+	assert.Equal(t, "config (updated)", msg)
+
+	assert.NoError(t, cl.Get(context.TODO(), client.ObjectKey{Namespace: "test", Name: "config"}, live))
+	assert.Equal(t, "b", live.Data["key"])
+}
+
+func TestReconcileActionShrinkingList(t *testing.T) {
+	scheme := newTestScheme()
+	cl := fake.NewClientBuilder().WithScheme(scheme).Build()
+	runner := NewControllerActionRunner(context.TODO(), cl, scheme, newTestOwner())
+
+	newService := func(ports ...int32) *corev1.Service {
+		service := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "svc", Namespace: "test"}}
+		for _, port := range ports {
+			service.Spec.Ports = append(service.Spec.Ports, corev1.ServicePort{Name: fmt.Sprintf("port-%d", port), Port: port})
+		}
+		return service
+	}
+
+	msg, err := GenericReconcileAction{Ref: newService(80, 443), Msg: "svc"}.Run(runner)
+	assert.NoError(t, err)
+	assert.Equal(t, "svc (created)", msg)
+
+	msg, err = GenericReconcileAction{Ref: newService(80, 443), Msg: "svc"}.Run(runner)
+	assert.NoError(t, err)
+	assert.Equal(t, "svc (unchanged)", msg)
+
+	// Items removed from a list are a change, not a prefix of the live list
+	msg, err = GenericReconcileAction{Ref: newService(80), Msg: "svc"}.Run(runner)
+	assert.NoError(t, err)
+	assert.Equal(t, "svc (updated)", msg)
+
+	live := &corev1.Service{}
+	assert.NoError(t, cl.Get(context.TODO(), client.ObjectKey{Namespace: "test", Name: "svc"}, live))
+	assert.Len(t, live.Spec.Ports, 1)
+}
+
+func TestPlan(t *testing.T) {
+	scheme := newTestScheme()
+	existing := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "config", Namespace: "test"},
+		Data:       map[string]string{"key": "a", "other": "c"},
+	}
+	cl := fake.NewClientBuilder().WithScheme(scheme).WithObjects(existing).Build()
+	var report RunReport
+	runner := NewControllerActionRunner(context.TODO(), cl, scheme, newTestOwner(), WithRunReport(&report))
+
+	desiredState := DesiredResourceState{
+		GenericUpdateAction{
+			Ref: &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: "config", Namespace: "test"},
+				Data:       map[string]string{"key": "b"},
+			},
+			Msg:       "update config",
+			Ownership: OwnershipNone,
+		},
+		GenericCreateAction{
+			Ref:       &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "secret", Namespace: "test"}},
+			Msg:       "create secret",
+			Ownership: OwnershipNone,
+		},
+	}
+
+	plan, err := runner.Plan(desiredState, DryRunClient)
+	assert.NoError(t, err)
+	assert.Len(t, plan, 2)
+	assert.Equal(t, VerbUpdate, plan[0].Verb)
+	assert.Equal(t, "update config", plan[0].Msg)
+	assert.Equal(t, "ConfigMap", plan[0].Kind)
+	inventoryDiff := []FieldDiff{
+		{Path: "metadata.annotations." + InventoryLabel("owner-uid"), New: "ConfigMap test/owner"},
+		{Path: "metadata.labels." + InventoryLabel("owner-uid"), New: "true"},
+	}
+	assert.Equal(t, append([]FieldDiff{{Path: "data.key", Old: "a", New: "b"}}, inventoryDiff...), plan[0].Diff)
+	assert.Equal(t, VerbCreate, plan[1].Verb)
+	assert.Equal(t, "secret", plan[1].Name)
+
+	plan, err = runner.Plan(desiredState, DryRunServer)
+	assert.NoError(t, err)
+	assert.Equal(t, append([]FieldDiff{{Path: "data.key", Old: "a", New: "b"}, {Path: "data.other", Old: "c"}}, inventoryDiff...), plan[0].Diff)
+
+	// Nothing was written, nor reported as run
+	assert.Nil(t, report)
+	live := &corev1.ConfigMap{}
+	assert.NoError(t, cl.Get(context.TODO(), client.ObjectKey{Namespace: "test", Name: "config"}, live))
+	assert.Equal(t, "a", live.Data["key"])
+	assert.True(t, apiErrors.IsNotFound(cl.Get(context.TODO(), client.ObjectKey{Namespace: "test", Name: "secret"}, &corev1.Secret{})))
+}
+
+func TestRunAllReport(t *testing.T) {
+	scheme := newTestScheme()
+	stale := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "stale", Namespace: "test"}}
+	cl := fake.NewClientBuilder().WithScheme(scheme).WithObjects(stale).Build()
+
+	relatedObjects := []corev1.ObjectReference{{Kind: "Secret", APIVersion: "v1", Namespace: "test", Name: "stale"}}
+	var runReport RunReport
+	runner := NewControllerActionRunner(context.TODO(), cl, scheme, newTestOwner(), WithRelatedObjects(&relatedObjects), WithRunReport(&runReport))
+
+	desiredState := DesiredResourceState{
+		GenericCreateAction{Ref: &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "config", Namespace: "test"}}, Msg: "create config"},
+		GenericDeleteAction{Ref: stale.DeepCopy(), Msg: "delete stale"},
+		GenericCreateAction{Ref: &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "config", Namespace: "test"}}, Msg: "create config again"},
+		GenericErrorAction{Msg: "never run"},
+	}
+
+	report, err := runner.RunAll(desiredState)
+
+	assert.True(t, apiErrors.IsAlreadyExists(err))
+	assert.Equal(t, report, runReport)
+	assert.Len(t, report, 4)
+	assert.Equal(t, OutcomeSuccess, report[0].Outcome)
+	assert.Equal(t, VerbCreate, report[0].Verb)
+	assert.Equal(t, "ConfigMap", report[0].Object.Kind)
+	assert.Equal(t, "config", report[0].Object.Name)
+	assert.Equal(t, VerbDelete, report[1].Verb)
+	assert.Equal(t, OutcomeFailed, report[2].Outcome)
+	assert.NotEmpty(t, report[2].Error)
+	assert.Equal(t, OutcomeSkipped, report[3].Outcome)
+	assert.Equal(t, "#3", report[3].ID)
+
+	assert.Len(t, relatedObjects, 1)
+	assert.Equal(t, "ConfigMap", relatedObjects[0].Kind)
+	assert.Equal(t, "config", relatedObjects[0].Name)
+}
+
+func TestRunAllEvents(t *testing.T) {
+	scheme := newTestScheme()
+	owner := newTestOwner()
+	owner.UID = "events-owner-uid"
+	cl := fake.NewClientBuilder().WithScheme(scheme).Build()
+	recorder := record.NewFakeRecorder(10)
+
+	// The second run emits the same warning, which is deduplicated
+	for n := 0; n < 2; n++ {
+		desiredState := DesiredResourceState{
+			GenericCreateAction{Ref: &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "config", Namespace: "test"}}, Msg: "create config"},
+			GenericCreateAction{Ref: &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "config", Namespace: "test"}}, Msg: "create config again"},
+		}
+		runner := NewControllerActionRunner(context.TODO(), cl, scheme, owner, WithEventRecorder(recorder), WithRunMode(RunModeContinueOnError))
+		_, err := runner.RunAll(desiredState)
+		assert.Error(t, err)
+	}
+
+	assert.Len(t, recorder.Events, 2)
+	assert.Equal(t, "Normal Created Created ConfigMap test/config", receiveEvent(recorder))
+	assert.Equal(t, `Warning FailedCreate Failed ConfigMap test/config: configmaps "config" already exists`, receiveEvent(recorder))
+
+	// Events emitted with another recorder are not deduplicated against this one
+	other := record.NewFakeRecorder(10)
+	runner := NewControllerActionRunner(context.TODO(), cl, scheme, owner, WithEventRecorder(other))
+	_, err := runner.RunAll(DesiredResourceState{
+		GenericCreateAction{Ref: &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "config", Namespace: "test"}}, Msg: "create config"},
+	})
+	assert.Error(t, err)
+	assert.Equal(t, `Warning FailedCreate Failed ConfigMap test/config: configmaps "config" already exists`, receiveEvent(other))
+}
+
+// receiveEvent returns the next event of recorder, or an empty string if there is none
+func receiveEvent(recorder *record.FakeRecorder) string {
+	select {
+	case event := <-recorder.Events:
+		return event
+	default:
+		return ""
+	}
+}
+
+func TestRunAllMetrics(t *testing.T) {
+	counter := actionsTotal.WithLabelValues("create", "", "v1", "ConfigMap", "SUCCESS")
+	before := testutil.ToFloat64(counter)
+
+	desiredState := DesiredResourceState{
+		GenericCreateAction{Ref: &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "metrics", Namespace: "test"}}},
+	}
+	_, err := newTestRunner().RunAll(desiredState)
+
+	assert.NoError(t, err)
+	assert.Equal(t, before+1, testutil.ToFloat64(counter))
+}
+
+func TestUpdateRetriesConflict(t *testing.T) {
+	scheme := newTestScheme()
+	existing := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "config", Namespace: "test"}}
+	cl := &concurrentWriteClientMock{
+		Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(existing).Build(),
+		write: func(c client.Client, obj client.Object) error {
+			live := &corev1.ConfigMap{}
+			if err := c.Get(context.TODO(), client.ObjectKeyFromObject(obj), live); err != nil {
+				return err
+			}
+			live.Labels = map[string]string{"updated": "true"}
+			return c.Update(context.TODO(), live)
+		},
+	}
+	runner := NewControllerActionRunner(context.TODO(), cl, scheme, newTestOwner())
+
+	ref := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "config", Namespace: "test"}}
+	_, err := GenericUpdateAction{Ref: ref, Ownership: OwnershipNone, Mutate: func() error {
+		ref.Data = map[string]string{"key": "value"}
+		return nil
+	}}.Run(runner)
+	assert.NoError(t, err)
+
+	live := &corev1.ConfigMap{}
+	assert.NoError(t, cl.Get(context.TODO(), client.ObjectKeyFromObject(existing), live))
+	assert.Equal(t, "value", live.Data["key"])
+	assert.Equal(t, "true", live.Labels["updated"])
+
+	// A stale object is not written over the concurrent change
+	stale := live.DeepCopy()
+	live.Labels["updated"] = "again"
+	assert.NoError(t, cl.Update(context.TODO(), live))
+	stale.Data["key"] = "stale"
+	_, err = GenericUpdateAction{Ref: stale, Ownership: OwnershipNone}.Run(runner)
+	assert.True(t, apiErrors.IsConflict(err))
+
+	assert.NoError(t, cl.Get(context.TODO(), client.ObjectKeyFromObject(existing), live))
+	assert.Equal(t, "value", live.Data["key"])
+	assert.Equal(t, "again", live.Labels["updated"])
+}
+
+func TestUpdateSkipUnchanged(t *testing.T) {
+	scheme := newTestScheme()
+	existing := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "config", Namespace: "test"}}
+	cl := fake.NewClientBuilder().WithScheme(scheme).WithObjects(existing).Build()
+	runner := NewControllerActionRunner(context.TODO(), cl, scheme, newTestOwner())
+
+	newState := func(value string) DesiredResourceState {
+		return DesiredResourceState{GenericUpdateAction{
+			Ref: &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: "config", Namespace: "test"},
+				Data:       map[string]string{"key": value},
+			},
+			Msg:           "update config",
+			SkipUnchanged: true,
+		}}
+	}
+
+	report, err := runner.RunAll(newState("a"))
+	assert.NoError(t, err)
+	assert.Equal(t, OutcomeSuccess, report[0].Outcome)
+
+	live := &corev1.ConfigMap{}
+	assert.NoError(t, cl.Get(context.TODO(), client.ObjectKeyFromObject(existing), live))
+	assert.NotEmpty(t, live.Annotations[SpecHashAnnotation])
+	resourceVersion := live.ResourceVersion
+
+	report, err = runner.RunAll(newState("a"))
+	assert.NoError(t, err)
+	assert.Equal(t, OutcomeUnchanged, report[0].Outcome)
+	assert.Equal(t, VerbNone, report[0].Verb)
+
+	assert.NoError(t, cl.Get(context.TODO(), client.ObjectKeyFromObject(existing), live))
+	assert.Equal(t, resourceVersion, live.ResourceVersion)
+
+	report, err = runner.RunAll(newState("b"))
+	assert.NoError(t, err)
+	assert.Equal(t, OutcomeSuccess, report[0].Outcome)
+
+	assert.NoError(t, cl.Get(context.TODO(), client.ObjectKeyFromObject(existing), live))
+	assert.Equal(t, "b", live.Data["key"])
+}
+
+func TestUpdateRecreatesImmutable(t *testing.T) {
+	scheme := newTestScheme()
+	owner := newTestOwner()
+	owner.UID = "recreate-owner-uid"
+	existing := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{Name: "migration", Namespace: "test"},
+		Spec:       batchv1.JobSpec{Parallelism: new(int32)},
+	}
+	cl := &immutableClientMock{Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(existing).Build()}
+	recorder := record.NewFakeRecorder(10)
+	runner := NewControllerActionRunner(context.TODO(), cl, scheme, owner, WithEventRecorder(recorder))
+
+	newJob := func() *batchv1.Job {
+		parallelism := int32(2)
+		return &batchv1.Job{
+			ObjectMeta: metav1.ObjectMeta{Name: "migration", Namespace: "test"},
+			Spec:       batchv1.JobSpec{Parallelism: &parallelism},
+		}
+	}
+
+	_, err := runner.RunAll(DesiredResourceState{GenericUpdateAction{Ref: newJob()}})
+	assert.True(t, IsImmutableFieldError(err))
+
+	report, err := runner.RunAll(DesiredResourceState{
+		GenericUpdateAction{Ref: newJob(), RecreateOnImmutable: true},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, OutcomeRecreated, report[0].Outcome)
+	assert.Equal(t, VerbRecreate, report[0].Verb)
+	// The pods of the old Job are not orphaned
+	assert.Equal(t, metav1.DeletePropagationBackground, *cl.deleteOptions.PropagationPolicy)
+
+	live := &batchv1.Job{}
+	assert.NoError(t, cl.Get(context.TODO(), client.ObjectKeyFromObject(existing), live))
+	assert.Equal(t, int32(2), *live.Spec.Parallelism)
+	assert.Len(t, live.OwnerReferences, 1)
+
+	assert.Equal(t, `Warning FailedUpdate Failed Job test/migration: Job.batch "migration" is invalid: spec.template: Invalid value: "null": field is immutable`, receiveEvent(recorder))
+	assert.Equal(t, "Warning Recreated Recreated Job test/migration to change immutable fields", receiveEvent(recorder))
+}
+
+func TestRecreateWaitingEvents(t *testing.T) {
+	scheme := newTestScheme()
+	existing := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: "migration", Namespace: "test", Finalizers: []string{"test/finalizer"}}}
+	cl := &immutableClientMock{Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(existing).Build()}
+	recorder := record.NewFakeRecorder(10)
+	runner := NewControllerActionRunner(context.TODO(), cl, scheme, newTestOwner(), WithEventRecorder(recorder))
+
+	desiredState := DesiredResourceState{GenericUpdateAction{
+		Ref:                 &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: "migration", Namespace: "test"}},
+		RecreateOnImmutable: true,
+	}}
+
+	// The finalizer keeps the old Job, so the recreate waits without an event
+	report, err := runner.RunAll(desiredState)
+	assert.True(t, IsResourceNotReadyError(err))
+	assert.Equal(t, OutcomeWaiting, report[0].Outcome)
+	assert.Empty(t, recorder.Events)
+
+	live := &batchv1.Job{}
+	assert.NoError(t, cl.Get(context.TODO(), client.ObjectKeyFromObject(existing), live))
+	live.Finalizers = nil
+	assert.NoError(t, cl.Client.Update(context.TODO(), live))
+
+	report, err = runner.RunAll(desiredState)
+	assert.NoError(t, err)
+	assert.Equal(t, OutcomeRecreated, report[0].Outcome)
+	assert.Equal(t, "Warning Recreated Recreated Job test/migration to change immutable fields", receiveEvent(recorder))
+}
+
+func TestDeleteAction(t *testing.T) {
+	scheme := newTestScheme()
+	finalized := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "finalized", Namespace: "test", Finalizers: []string{"test/finalizer"}}}
+	cl := fake.NewClientBuilder().WithScheme(scheme).WithObjects(finalized).Build()
+	runner := NewControllerActionRunner(context.TODO(), cl, scheme, newTestOwner())
+
+	missing := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "missing", Namespace: "test"}}
+	_, err := GenericDeleteAction{Ref: missing}.Run(runner)
+	assert.True(t, apiErrors.IsNotFound(err))
+
+	// A resource that is already gone was not deleted by the action, so there is no event
+	recorder := record.NewFakeRecorder(10)
+	relatedObjects := []corev1.ObjectReference{{Kind: "ConfigMap", APIVersion: "v1", Namespace: "test", Name: "missing"}}
+	report, err := NewControllerActionRunner(context.TODO(), cl, scheme, newTestOwner(), WithEventRecorder(recorder), WithRelatedObjects(&relatedObjects)).RunAll(DesiredResourceState{
+		GenericDeleteAction{Ref: missing, IgnoreNotFound: true},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, OutcomeUnchanged, report[0].Outcome)
+	assert.Empty(t, recorder.Events)
+	assert.Empty(t, relatedObjects)
+
+	// The finalizer keeps the object until it is removed
+	action := GenericDeleteAction{Ref: finalized.DeepCopy(), PropagationPolicy: metav1.DeletePropagationForeground, WaitForDeletion: true}
+	_, err = action.Run(runner)
+	assert.True(t, IsResourceNotReadyError(err))
+	assert.EqualError(t, err, "ConfigMap test/finalized is still being deleted")
+
+	live := &corev1.ConfigMap{}
+	assert.NoError(t, cl.Get(context.TODO(), client.ObjectKeyFromObject(finalized), live))
+	live.Finalizers = nil
+	assert.NoError(t, cl.Update(context.TODO(), live))
+
+	_, err = action.Run(runner)
+	assert.NoError(t, err)
+}
+
+func TestDeleteAllOfAction(t *testing.T) {
+	scheme := newTestScheme()
+	cl := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "a", Namespace: "test", Labels: map[string]string{"app": "old"}}},
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "b", Namespace: "test", Labels: map[string]string{"app": "new"}}},
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "c", Namespace: "other", Labels: map[string]string{"app": "old"}}},
+	).Build()
+	runner := NewControllerActionRunner(context.TODO(), cl, scheme, newTestOwner())
+
+	// Without a selector or a namespace, the deletion would not be limited to the resources of the CR
+	_, err := DeleteAllOfAction{Ref: &corev1.ConfigMap{}, Namespace: "test"}.Run(runner)
+	assert.EqualError(t, err, "deleting all ConfigMap requires a label selector")
+	_, err = DeleteAllOfAction{Ref: &corev1.ConfigMap{}, Namespace: "test", Selector: labels.Everything()}.Run(runner)
+	assert.EqualError(t, err, "deleting all ConfigMap requires a label selector")
+	_, err = DeleteAllOfAction{Ref: &corev1.ConfigMap{}, Selector: labels.SelectorFromSet(labels.Set{"app": "old"})}.Run(runner)
+	assert.EqualError(t, err, "deleting all ConfigMap requires a namespace")
+
+	report, err := runner.RunAll(DesiredResourceState{
+		DeleteAllOfAction{
+			Ref:       &corev1.ConfigMap{},
+			Namespace: "test",
+			Selector:  labels.SelectorFromSet(labels.Set{"app": "old"}),
+			Msg:       "delete old configs",
+		},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, VerbDeleteCollection, report[0].Verb)
+
+	list := &corev1.ConfigMapList{}
+	assert.NoError(t, cl.List(context.TODO(), list))
+	var names []string
+	for _, item := range list.Items {
+		names = append(names, item.Name)
+	}
+	assert.ElementsMatch(t, []string{"b", "c"}, names)
+}
+
+func TestCreateAdoptExisting(t *testing.T) {
+	scheme := newTestScheme()
+	controller := true
+	unowned := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "unowned", Namespace: "test"},
+		Data:       map[string]string{"key": "a"},
+	}
+	foreign := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{
+		Name:            "foreign",
+		Namespace:       "test",
+		OwnerReferences: []metav1.OwnerReference{{APIVersion: "v1", Kind: "ConfigMap", Name: "other", UID: "other-uid", Controller: &controller}},
+	}}
+	cl := fake.NewClientBuilder().WithScheme(scheme).WithObjects(unowned, foreign).Build()
+	runner := NewControllerActionRunner(context.TODO(), cl, scheme, newTestOwner())
+
+	newConfigMap := func(name string) *corev1.ConfigMap {
+		return &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "test"},
+			Data:       map[string]string{"key": "b"},
+		}
+	}
+
+	_, err := GenericCreateAction{Ref: newConfigMap("unowned")}.Run(runner)
+	assert.True(t, apiErrors.IsAlreadyExists(err))
+
+	// Adopting sets the owner without changing the existing resource
+	report, err := runner.RunAll(DesiredResourceState{GenericCreateAction{Ref: newConfigMap("unowned"), AdoptExisting: true}})
+	assert.NoError(t, err)
+	assert.Equal(t, OutcomeSuccess, report[0].Outcome)
+	assert.Equal(t, VerbUpdate, report[0].Verb)
+	live := &corev1.ConfigMap{}
+	assert.NoError(t, cl.Get(context.TODO(), client.ObjectKeyFromObject(unowned), live))
+	assert.Equal(t, "a", live.Data["key"])
+	assert.Equal(t, types.UID("owner-uid"), metav1.GetControllerOf(live).UID)
+	assert.Equal(t, "true", live.Labels[InventoryLabel("owner-uid")])
+
+	report, err = runner.RunAll(DesiredResourceState{GenericCreateAction{Ref: newConfigMap("unowned"), AdoptExisting: true}})
+	assert.NoError(t, err)
+	assert.Equal(t, OutcomeUnchanged, report[0].Outcome)
+
+	_, err = GenericCreateAction{Ref: newConfigMap("unowned"), AdoptExisting: true, UpdateAdopted: true}.Run(runner)
+	assert.NoError(t, err)
+	assert.NoError(t, cl.Get(context.TODO(), client.ObjectKeyFromObject(unowned), live))
+	assert.Equal(t, "b", live.Data["key"])
+	assert.Equal(t, types.UID("owner-uid"), metav1.GetControllerOf(live).UID)
+
+	_, err = GenericCreateAction{Ref: newConfigMap("foreign"), AdoptExisting: true, UpdateAdopted: true}.Run(runner)
+	assert.True(t, IsOwnershipConflictError(err))
+	assert.EqualError(t, err, "test/foreign is already controlled by ConfigMap other")
+}
+
+func TestWaitForReadyAction(t *testing.T) {
+	scheme := newTestScheme()
+	cl := fake.NewClientBuilder().WithScheme(scheme).Build()
+	runner := NewControllerActionRunner(context.TODO(), cl, scheme, newTestOwner())
+
+	newDesiredState := func() DesiredResourceState {
+		return DesiredResourceState{
+			WaitForReadyAction{Ref: &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: "migration", Namespace: "test"}}, Msg: "wait for migration"},
+			GenericCreateAction{Ref: &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "config", Namespace: "test"}}, Msg: "create config"},
+		}
+	}
+
+	report, err := runner.RunAll(newDesiredState())
+	assert.True(t, IsResourceNotReadyError(err))
+	assert.EqualError(t, err, "Job test/migration is not ready")
+	assert.Equal(t, OutcomeWaiting, report[0].Outcome)
+	assert.Equal(t, OutcomeSkipped, report[1].Outcome)
+
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{Name: "migration", Namespace: "test"},
+		Status:     batchv1.JobStatus{Succeeded: 1},
+	}
+	assert.NoError(t, cl.Create(context.TODO(), job))
+
+	report, err = runner.RunAll(newDesiredState())
+	assert.NoError(t, err)
+	assert.Equal(t, OutcomeSuccess, report[1].Outcome)
+}
@@ -0,0 +1,143 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package actions
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// SpecHashAnnotation holds a hash of the desired state an object was last updated with
+const SpecHashAnnotation = "operator-utils.jeesmon.github.io/spec-hash"
+
+// Metadata fields that are part of the desired state. Everything else in metadata
+// is maintained by the API server.
+var desiredMetadataFields = []string{"labels", "annotations", "ownerReferences", "finalizers"}
+
+// isUpToDate reports whether every field set on desired has the same value on live.
+// Fields that are not set on desired (server defaults, fields managed by other
+// controllers, status) are ignored, but lists must hold the same number of items.
+// The inventory labels and annotations are ignored too, they are only written along
+// with other changes.
+func isUpToDate(desired client.Object, live client.Object) (bool, error) {
+	desiredFields, err := desiredStateFields(desired)
+	if err != nil {
+		return false, err
+	}
+	liveFields, err := desiredStateFields(live)
+	if err != nil {
+		return false, err
+	}
+	deleteMetadataKeys(desiredFields, isInventoryKey)
+	deleteMetadataKeys(liveFields, isInventoryKey)
+
+	return isDerivative(desiredFields, liveFields), nil
+}
+
+// isDerivative works like equality.Semantic.DeepDerivative on unstructured values, except
+// that a desired list only matches a live list of the same length. DeepDerivative accepts
+// a prefix of the live list, so items removed from the desired state would never be
+// written.
+func isDerivative(desired interface{}, live interface{}) bool {
+	switch desiredValue := desired.(type) {
+	case nil:
+		return true
+	case string:
+		if desiredValue == "" {
+			return true
+		}
+	case map[string]interface{}:
+		liveValue, _ := live.(map[string]interface{})
+		for key, value := range desiredValue {
+			if !isDerivative(value, liveValue[key]) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		liveValue, _ := live.([]interface{})
+		if len(desiredValue) != len(liveValue) {
+			return false
+		}
+		for n := range desiredValue {
+			if !isDerivative(desiredValue[n], liveValue[n]) {
+				return false
+			}
+		}
+		return true
+	}
+	return equality.Semantic.DeepEqual(desired, live)
+}
+
+// desiredStateFields converts obj to a map holding only the fields a reconciler can own
+func desiredStateFields(obj client.Object) (map[string]interface{}, error) {
+	// Unstructured objects are converted without a copy, so copy them first to leave obj alone
+	fields, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj.DeepCopyObject())
+	if err != nil {
+		return nil, err
+	}
+
+	delete(fields, "apiVersion")
+	delete(fields, "kind")
+	delete(fields, "status")
+
+	metadata := map[string]interface{}{}
+	if m, ok := fields["metadata"].(map[string]interface{}); ok {
+		for _, field := range desiredMetadataFields {
+			if value, found := m[field]; found {
+				metadata[field] = value
+			}
+		}
+	}
+	fields["metadata"] = metadata
+
+	return fields, nil
+}
+
+// deleteMetadataKeys removes the labels and annotations with a key matching drop from
+// the desired state fields, and the labels and annotations left empty
+func deleteMetadataKeys(fields map[string]interface{}, drop func(key string) bool) {
+	metadata := fields["metadata"].(map[string]interface{})
+	for _, field := range []string{"labels", "annotations"} {
+		values, ok := metadata[field].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		for key := range values {
+			if drop(key) {
+				delete(values, key)
+			}
+		}
+		if len(values) == 0 {
+			delete(metadata, field)
+		}
+	}
+}
+
+// specHash returns a stable hash of the desired state fields of obj, ignoring the hash
+// annotation itself and the inventory labels and annotations
+func specHash(obj client.Object) (string, error) {
+	fields, err := desiredStateFields(obj)
+	if err != nil {
+		return "", err
+	}
+
+	deleteMetadataKeys(fields, func(key string) bool {
+		return key == SpecHashAnnotation || isInventoryKey(key)
+	})
+
+	// encoding/json sorts map keys, so equal fields always give the same bytes
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
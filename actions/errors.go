@@ -0,0 +1,156 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package actions
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	apiErrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// ApplyConflictError is returned when a server-side apply is rejected because
+// fields in the patch are owned by another field manager
+type ApplyConflictError struct {
+	PartialObject client.Object
+	FieldOwner    string
+	Err           error
+}
+
+func (e *ApplyConflictError) Error() string {
+	return fmt.Sprintf("%v/%v has fields owned by another manager than %v: %v", e.PartialObject.GetNamespace(), e.PartialObject.GetName(), e.FieldOwner, e.Err)
+}
+
+func (e *ApplyConflictError) Unwrap() error {
+	return e.Err
+}
+
+func IsApplyConflictError(err error) bool {
+	var conflict *ApplyConflictError
+	return errors.As(err, &conflict)
+}
+
+// OwnershipConflictError is returned when adopting an existing resource that is
+// controlled by another owner than the CR
+type OwnershipConflictError struct {
+	PartialObject client.Object
+	Owner         metav1.OwnerReference
+}
+
+func (e *OwnershipConflictError) Error() string {
+	return fmt.Sprintf("%v/%v is already controlled by %v %v", e.PartialObject.GetNamespace(), e.PartialObject.GetName(), e.Owner.Kind, e.Owner.Name)
+}
+
+func IsOwnershipConflictError(err error) bool {
+	var conflict *OwnershipConflictError
+	return errors.As(err, &conflict)
+}
+
+// IsImmutableFieldError reports whether err is the rejection of an update that changes
+// an immutable field, such as a Job template, a Deployment selector or a Service clusterIP
+func IsImmutableFieldError(err error) bool {
+	if !apiErrors.IsInvalid(err) {
+		return false
+	}
+	var status apiErrors.APIStatus
+	if errors.As(err, &status) && status.Status().Details != nil {
+		for _, cause := range status.Status().Details.Causes {
+			if strings.Contains(cause.Message, "immutable") {
+				return true
+			}
+		}
+	}
+	return strings.Contains(err.Error(), "immutable")
+}
+
+// ActionError describes a failed action of a DesiredResourceState run as a dependency
+// graph or in RunModeContinueOnError. Object references the last resource the action wrote, if any, and
+// Skipped holds the actions that were not run because they depend on it.
+type ActionError struct {
+	Index   int
+	ID      string
+	Msg     string
+	Err     error
+	Object  *corev1.ObjectReference
+	Skipped []string
+}
+
+func (e *ActionError) Error() string {
+	msg := fmt.Sprintf("action %s failed: %v", e.ID, e.Err)
+	if e.Object != nil {
+		msg = fmt.Sprintf("action %s (%s) failed: %v", e.ID, objectReferenceString(e.Object), e.Err)
+	}
+	if len(e.Skipped) > 0 {
+		msg += fmt.Sprintf(" (skipped %s)", strings.Join(e.Skipped, ", "))
+	}
+	return msg
+}
+
+func (e *ActionError) Unwrap() error {
+	return e.Err
+}
+
+// AggregateActionError is returned by RunAll in RunModeContinueOnError and holds every
+// action that failed
+type AggregateActionError struct {
+	Errors []*ActionError
+}
+
+func newAggregateActionError(errs []*ActionError) error {
+	if len(errs) == 0 {
+		return nil
+	}
+	return &AggregateActionError{Errors: errs}
+}
+
+func (e *AggregateActionError) Error() string {
+	msgs := make([]string, 0, len(e.Errors))
+	for _, err := range e.Errors {
+		msgs = append(msgs, err.Error())
+	}
+	return fmt.Sprintf("%d actions failed: %s", len(e.Errors), strings.Join(msgs, "; "))
+}
+
+// IsResourceNotReady reports whether every failed action is only waiting for a resource
+func (e *AggregateActionError) IsResourceNotReady() bool {
+	for _, err := range e.Errors {
+		if !IsResourceNotReadyError(err) {
+			return false
+		}
+	}
+	return true
+}
+
+// FailingResources returns the resources of the failed actions, or the action names
+// for actions that did not write a resource
+func (e *AggregateActionError) FailingResources() []string {
+	resources := make([]string, 0, len(e.Errors))
+	for _, err := range e.Errors {
+		if err.Object != nil {
+			resources = append(resources, objectReferenceString(err.Object))
+		} else {
+			resources = append(resources, "action "+err.ID)
+		}
+	}
+	return resources
+}
+
+func objectReferenceString(ref *corev1.ObjectReference) string {
+	name := ref.Name
+	if ref.Namespace != "" && name != "" {
+		name = ref.Namespace + "/" + name
+	} else if ref.Namespace != "" {
+		// References to all resources of a kind in a namespace have no name
+		name = ref.Namespace
+	}
+	if ref.Kind == "" {
+		return name
+	}
+	return ref.Kind + " " + name
+}
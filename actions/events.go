@@ -0,0 +1,167 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package actions
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/flowcontrol"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// Identical events for the same owner are only emitted once per window
+	EventDedupWindow = 10 * time.Minute
+	// Events emitted for a single owner are rate limited to EventQPS with bursts of EventBurst
+	EventQPS   = 0.2
+	EventBurst = 25
+
+	// Owners without events for longer than the dedup window are forgotten once
+	// this many owners are tracked
+	maxTrackedEventOwners = 1024
+)
+
+// A runner only lives for a single reconcile, so the events it emitted are tracked
+// for all runners sharing its recorder
+var eventFilters sync.Map
+
+// eventFilterFor returns the filter of the events emitted with recorder
+func eventFilterFor(recorder record.EventRecorder) *ownerEventFilter {
+	filter, _ := eventFilters.LoadOrStore(recorder, &ownerEventFilter{owners: map[types.UID]*ownerEvents{}})
+	return filter.(*ownerEventFilter)
+}
+
+type ownerEventFilter struct {
+	sync.Mutex
+	owners map[types.UID]*ownerEvents
+}
+
+type ownerEvents struct {
+	limiter  flowcontrol.PassiveRateLimiter
+	emitted  map[string]time.Time
+	lastSeen time.Time
+}
+
+// allow reports whether an event may be emitted for owner, and remembers it if so
+func (f *ownerEventFilter) allow(owner client.Object, eventtype string, reason string, message string) bool {
+	f.Lock()
+	defer f.Unlock()
+
+	now := time.Now()
+	events, found := f.owners[owner.GetUID()]
+	if !found {
+		if len(f.owners) >= maxTrackedEventOwners {
+			f.forgetIdleOwners(now)
+		}
+		events = &ownerEvents{
+			limiter: flowcontrol.NewTokenBucketPassiveRateLimiter(EventQPS, EventBurst),
+			emitted: map[string]time.Time{},
+		}
+		f.owners[owner.GetUID()] = events
+	}
+	events.lastSeen = now
+
+	key := strings.Join([]string{eventtype, reason, message}, "/")
+	if emitted, found := events.emitted[key]; found && now.Sub(emitted) < EventDedupWindow {
+		return false
+	}
+	if !events.limiter.TryAccept() {
+		return false
+	}
+
+	for k, emitted := range events.emitted {
+		if now.Sub(emitted) >= EventDedupWindow {
+			delete(events.emitted, k)
+		}
+	}
+	events.emitted[key] = now
+	return true
+}
+
+func (f *ownerEventFilter) forgetIdleOwners(now time.Time) {
+	for uid, events := range f.owners {
+		if now.Sub(events.lastSeen) >= EventDedupWindow {
+			delete(f.owners, uid)
+		}
+	}
+}
+
+// WithEventRecorder makes the runner emit events on the CR for every resource it
+// writes and for every failed action. Events are deduplicated and rate limited per
+// recorder, so pass the same recorder to the runners of every reconcile, e.g. the one
+// created once for the controller
+func WithEventRecorder(recorder record.EventRecorder) RunnerOption {
+	return func(i *ControllerActionRunner) {
+		i.recorder = recorder
+		i.eventFilter = eventFilterFor(recorder)
+	}
+}
+
+var eventReasons = map[Verb]string{
+	VerbCreate:           "Created",
+	VerbUpdate:           "Updated",
+	VerbApply:            "Applied",
+	VerbDelete:           "Deleted",
+	VerbRecreate:         "Recreated",
+	VerbDeleteCollection: "DeletedCollection",
+}
+
+var failedEventReasons = map[Verb]string{
+	VerbCreate:           "FailedCreate",
+	VerbUpdate:           "FailedUpdate",
+	VerbApply:            "FailedApply",
+	VerbDelete:           "FailedDelete",
+	VerbRecreate:         "FailedRecreate",
+	VerbDeleteCollection: "FailedDeleteCollection",
+}
+
+// emitEvent emits the event describing the result of an action
+func (i *ControllerActionRunner) emitEvent(entry ActionReport) {
+	if i.recorder == nil || i.dryRun != "" {
+		return
+	}
+
+	resource := "action " + entry.ID
+	if entry.Object != nil {
+		resource = objectReferenceString(entry.Object)
+	}
+
+	if entry.Outcome == OutcomeFailed {
+		reason, found := failedEventReasons[entry.Verb]
+		if !found {
+			reason = "ActionFailed"
+		}
+		i.event(corev1.EventTypeWarning, reason, fmt.Sprintf("Failed %s: %s", resource, entry.Error))
+		return
+	}
+
+	reason, found := eventReasons[entry.Verb]
+	if !found || entry.Object == nil || entry.Outcome == OutcomeUnchanged {
+		return
+	}
+	// A recreate waiting for the deletion of the old resource has not recreated it yet, its
+	// warning is emitted by the run that completes it
+	if entry.Verb == VerbRecreate && entry.Outcome == OutcomeWaiting {
+		return
+	}
+	// Recreating drops the state of the resource, e.g. the pods of a Job, so it is a warning
+	if entry.Outcome == OutcomeRecreated {
+		i.event(corev1.EventTypeWarning, reason, fmt.Sprintf("%s %s to change immutable fields", reason, resource))
+		return
+	}
+	i.event(corev1.EventTypeNormal, reason, fmt.Sprintf("%s %s", reason, resource))
+}
+
+func (i *ControllerActionRunner) event(eventtype string, reason string, message string) {
+	if i.eventFilter.allow(i.cr, eventtype, reason, message) {
+		i.recorder.Event(i.cr, eventtype, reason, message)
+	}
+}
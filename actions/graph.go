@@ -0,0 +1,239 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package actions
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	DefaultMaxConcurrentActions = 4
+)
+
+// RunMode selects what RunAll does when an action fails
+type RunMode string
+
+const (
+	// RunModeStopOnError stops the run at the first failed action
+	RunModeStopOnError RunMode = "StopOnError"
+	// RunModeContinueOnError runs every action whose dependencies succeeded and
+	// returns all failures at the end
+	RunModeContinueOnError RunMode = "ContinueOnError"
+)
+
+// DependentAction gives an action an ID and the IDs of the actions that must succeed
+// before it runs. When a DesiredResourceState holds a DependentAction, RunAll runs the
+// actions as a dependency graph: actions without dependencies, including the ones that
+// are not wrapped in a DependentAction, run concurrently.
+type DependentAction struct {
+	ID        string
+	DependsOn []string
+	Action    ControllerAction
+}
+
+func (i DependentAction) Run(runner ActionRunner) (string, error) {
+	return i.Action.Run(runner)
+}
+
+type actionGraph struct {
+	names      []string
+	dependents [][]int
+	indegree   []int
+}
+
+type actionResult struct {
+	index    int
+	msg      string
+	err      error
+	verb     Verb
+	target   client.Object
+	duration time.Duration
+	outcome  Outcome
+}
+
+// actionName returns the ID of a DependentAction or the index of any other action
+func actionName(index int, action ControllerAction) string {
+	if dependent, ok := action.(DependentAction); ok && dependent.ID != "" {
+		return dependent.ID
+	}
+	return fmt.Sprintf("#%d", index)
+}
+
+func hasDependencies(desiredState DesiredResourceState) bool {
+	for _, action := range desiredState {
+		if _, ok := action.(DependentAction); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func newActionGraph(desiredState DesiredResourceState) (*actionGraph, error) {
+	graph := &actionGraph{
+		names:      make([]string, len(desiredState)),
+		dependents: make([][]int, len(desiredState)),
+		indegree:   make([]int, len(desiredState)),
+	}
+
+	ids := map[string]int{}
+	for index, action := range desiredState {
+		graph.names[index] = actionName(index, action)
+		dependent, ok := action.(DependentAction)
+		if !ok || dependent.ID == "" {
+			continue
+		}
+		if _, found := ids[dependent.ID]; found {
+			return nil, errors.Errorf("duplicate action ID %q", dependent.ID)
+		}
+		ids[dependent.ID] = index
+	}
+
+	for index, action := range desiredState {
+		dependent, ok := action.(DependentAction)
+		if !ok {
+			continue
+		}
+		for _, id := range dependent.DependsOn {
+			dependency, found := ids[id]
+			if !found {
+				return nil, errors.Errorf("action %s depends on unknown action %q", graph.names[index], id)
+			}
+			graph.dependents[dependency] = append(graph.dependents[dependency], index)
+			graph.indegree[index]++
+		}
+	}
+
+	if cycle := graph.findCycle(); cycle != nil {
+		return nil, errors.Errorf("dependency cycle between actions: %s", strings.Join(cycle, " -> "))
+	}
+
+	return graph, nil
+}
+
+// findCycle returns the names of the actions forming a cycle, or nil if there is none
+func (g *actionGraph) findCycle() []string {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make([]int, len(g.names))
+	var path []int
+
+	var visit func(index int) []string
+	visit = func(index int) []string {
+		state[index] = visiting
+		path = append(path, index)
+		for _, next := range g.dependents[index] {
+			if state[next] == visiting {
+				var cycle []string
+				for n := len(path) - 1; n >= 0; n-- {
+					if path[n] == next {
+						for _, node := range path[n:] {
+							cycle = append(cycle, g.names[node])
+						}
+						break
+					}
+				}
+				return append(cycle, g.names[next])
+			}
+			if state[next] == unvisited {
+				if cycle := visit(next); cycle != nil {
+					return cycle
+				}
+			}
+		}
+		path = path[:len(path)-1]
+		state[index] = visited
+		return nil
+	}
+
+	for index := range g.names {
+		if state[index] == unvisited {
+			if cycle := visit(index); cycle != nil {
+				return cycle
+			}
+		}
+	}
+	return nil
+}
+
+// dependentsOf returns the names of all actions that directly or transitively depend on index
+func (g *actionGraph) dependentsOf(index int) []string {
+	seen := map[int]bool{}
+	var names []string
+	queue := append([]int{}, g.dependents[index]...)
+	for len(queue) > 0 {
+		next := queue[0]
+		queue = queue[1:]
+		if seen[next] {
+			continue
+		}
+		seen[next] = true
+		names = append(names, g.names[next])
+		queue = append(queue, g.dependents[next]...)
+	}
+	return names
+}
+
+// runGraph runs every action once all of its dependencies succeeded, with at most
+// maxConcurrentActions actions in flight. After a failure no new action is started,
+// unless the runner is in RunModeContinueOnError.
+func (i *ControllerActionRunner) runGraph(desiredState DesiredResourceState, report RunReport) error {
+	graph, err := newActionGraph(desiredState)
+	if err != nil {
+		return err
+	}
+
+	pending := append([]int{}, graph.indegree...)
+	var ready []int
+	for index, count := range pending {
+		if count == 0 {
+			ready = append(ready, index)
+		}
+	}
+
+	results := make(chan actionResult)
+	running := 0
+	var failures []*ActionError
+
+	for {
+		for (len(failures) == 0 || i.runMode == RunModeContinueOnError) && len(ready) > 0 && running < i.maxConcurrentActions {
+			index := ready[0]
+			ready = ready[1:]
+			running++
+			go func(index int) {
+				results <- i.runAction(index, desiredState[index])
+			}(index)
+		}
+		if running == 0 {
+			break
+		}
+
+		result := <-results
+		running--
+		i.record(report, result)
+		if result.err != nil {
+			failures = append(failures, i.actionError(result, graph.names[result.index], graph.dependentsOf(result.index)))
+			continue
+		}
+		for _, dependent := range graph.dependents[result.index] {
+			pending[dependent]--
+			if pending[dependent] == 0 {
+				ready = append(ready, dependent)
+			}
+		}
+	}
+
+	if len(failures) > 0 && i.runMode != RunModeContinueOnError {
+		return failures[0]
+	}
+	return newAggregateActionError(failures)
+}
@@ -0,0 +1,140 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package actions
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/jeesmon/operator-utils/status"
+	conditions "github.com/openshift/custom-resource-status/conditions/v1"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+type funcAction func() error
+
+func (f funcAction) Run(runner ActionRunner) (string, error) {
+	return "func", f()
+}
+
+type orderRecorder struct {
+	sync.Mutex
+	order []string
+}
+
+func (r *orderRecorder) action(id string, err error, dependsOn ...string) DependentAction {
+	return DependentAction{
+		ID:        id,
+		DependsOn: dependsOn,
+		Action: funcAction(func() error {
+			r.Lock()
+			defer r.Unlock()
+			r.order = append(r.order, id)
+			return err
+		}),
+	}
+}
+
+func (r *orderRecorder) indexOf(id string) int {
+	for index, name := range r.order {
+		if name == id {
+			return index
+		}
+	}
+	return -1
+}
+
+func newTestRunner(opts ...RunnerOption) ActionRunner {
+	scheme := newTestScheme()
+	cl := fake.NewClientBuilder().WithScheme(scheme).Build()
+	return NewControllerActionRunner(context.TODO(), cl, scheme, newTestOwner(), opts...)
+}
+
+func TestRunAllGraph(t *testing.T) {
+	recorder := &orderRecorder{}
+	desiredState := DesiredResourceState{
+		recorder.action("deployment", nil, "migration", "config"),
+		recorder.action("migration", nil, "secret"),
+		recorder.action("secret", nil),
+		recorder.action("config", nil),
+	}
+
+	_, err := newTestRunner(WithMaxConcurrentActions(2)).RunAll(desiredState)
+
+	assert.NoError(t, err)
+	assert.Len(t, recorder.order, 4)
+	assert.Less(t, recorder.indexOf("secret"), recorder.indexOf("migration"))
+	assert.Less(t, recorder.indexOf("migration"), recorder.indexOf("deployment"))
+	assert.Less(t, recorder.indexOf("config"), recorder.indexOf("deployment"))
+}
+
+func TestRunAllGraphFailure(t *testing.T) {
+	recorder := &orderRecorder{}
+	desiredState := DesiredResourceState{
+		recorder.action("secret", errors.New("boom")),
+		recorder.action("migration", nil, "secret"),
+		recorder.action("deployment", nil, "migration"),
+	}
+
+	_, err := newTestRunner().RunAll(desiredState)
+
+	var actionErr *ActionError
+	assert.True(t, errors.As(err, &actionErr))
+	assert.Equal(t, "secret", actionErr.ID)
+	assert.Equal(t, []string{"migration", "deployment"}, actionErr.Skipped)
+	assert.Equal(t, []string{"secret"}, recorder.order)
+}
+
+func TestRunAllGraphCycle(t *testing.T) {
+	recorder := &orderRecorder{}
+	desiredState := DesiredResourceState{
+		recorder.action("a", nil, "c"),
+		recorder.action("b", nil, "a"),
+		recorder.action("c", nil, "b"),
+	}
+
+	_, err := newTestRunner().RunAll(desiredState)
+
+	assert.EqualError(t, err, "dependency cycle between actions: a -> b -> c -> a")
+	assert.Empty(t, recorder.order)
+}
+
+func TestRunAllContinueOnError(t *testing.T) {
+	scheme := newTestScheme()
+	owner := newTestOwner()
+	existing := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "config", Namespace: "test"}}
+	cl := fake.NewClientBuilder().WithScheme(scheme).WithObjects(owner, existing).Build()
+	runner := NewControllerActionRunner(context.TODO(), cl, scheme, owner, WithRunMode(RunModeContinueOnError))
+
+	recorder := &orderRecorder{}
+	desiredState := DesiredResourceState{
+		GenericCreateAction{Ref: &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "config", Namespace: "test"}}, Msg: "create config"},
+		recorder.action("service", nil).Action,
+		recorder.action("broken", errors.New("boom")).Action,
+	}
+
+	_, err := runner.RunAll(desiredState)
+
+	var aggregate *AggregateActionError
+	assert.True(t, errors.As(err, &aggregate))
+	assert.Len(t, aggregate.Errors, 2)
+	assert.Equal(t, 0, aggregate.Errors[0].Index)
+	assert.Equal(t, "create config", aggregate.Errors[0].Msg)
+	assert.Equal(t, "ConfigMap", aggregate.Errors[0].Object.Kind)
+	assert.Equal(t, 2, aggregate.Errors[1].Index)
+	assert.Equal(t, []string{"service", "broken"}, recorder.order)
+	assert.Equal(t, []string{"ConfigMap test/config", "action #2"}, aggregate.FailingResources())
+
+	var statusConditions []conditions.Condition
+	_, err = ManageError(cl, context.TODO(), owner, &statusConditions, err)
+	assert.Error(t, err)
+	assert.Equal(t, string(status.ReasonFailing), statusConditions[0].Reason)
+	assert.Equal(t, "Failing resources: ConfigMap test/config, action #2", statusConditions[0].Message)
+}
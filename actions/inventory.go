@@ -0,0 +1,235 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package actions
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	apiErrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// InventoryLabelPrefix starts the keys of the inventory labels and annotations. Every CR
+// that created or updated a resource sets its own, so a resource shared by several CRs is
+// in the inventory of each of them.
+const InventoryLabelPrefix = "owner.operator-utils.jeesmon.github.io/"
+
+const inventoryLabelValue = "true"
+
+// InventoryLabel returns the key of the inventory label of the CR with uid. The annotation
+// with the same key names the CR.
+func InventoryLabel(uid types.UID) string {
+	return InventoryLabelPrefix + string(uid)
+}
+
+func isInventoryKey(key string) bool {
+	return strings.HasPrefix(key, InventoryLabelPrefix)
+}
+
+// PruneConfig configures the deletion of resources owned by the CR that are no longer
+// part of its DesiredResourceState
+type PruneConfig struct {
+	// GroupVersionKinds lists the kinds of resources that may be pruned
+	GroupVersionKinds []schema.GroupVersionKind
+	// DryRun only reports the resources that would be pruned
+	DryRun bool
+}
+
+// WithPrune makes RunAll delete, after every successful run, the resources of the
+// configured kinds that carry the inventory label of the CR but were not written by
+// the run. Resources with other owners are not deleted, the CR is only removed from
+// their owners.
+func WithPrune(config PruneConfig) RunnerOption {
+	return func(i *ControllerActionRunner) {
+		i.prune = &config
+	}
+}
+
+// An action to delete a resource that is no longer part of the desired state
+type pruneAction struct {
+	Ref client.Object
+	Msg string
+}
+
+func (i pruneAction) Run(runner ActionRunner) (string, error) {
+	err := runner.Delete(i.Ref)
+	if apiErrors.IsNotFound(err) {
+		return i.Msg, nil
+	}
+	return i.Msg, err
+}
+
+// An action to remove the CR from the owners of a shared resource that is no longer part
+// of its desired state, leaving the resource to its other owners
+type releaseAction struct {
+	Ref client.Object
+	Msg string
+}
+
+func (i releaseAction) Run(runner ActionRunner) (string, error) {
+	releaser, ok := runner.(*ControllerActionRunner)
+	if !ok {
+		return i.Msg, errors.Errorf("releasing %s/%s requires a ControllerActionRunner", i.Ref.GetNamespace(), i.Ref.GetName())
+	}
+	err := releaser.release(i.Ref)
+	if apiErrors.IsNotFound(err) {
+		return i.Msg, nil
+	}
+	return i.Msg, err
+}
+
+// hasOtherOwners reports whether obj has owner references to, or is in the inventory of,
+// others than the CR with uid
+func hasOtherOwners(obj client.Object, uid types.UID) bool {
+	for _, ref := range obj.GetOwnerReferences() {
+		if ref.UID != uid {
+			return true
+		}
+	}
+	for key := range obj.GetLabels() {
+		if isInventoryKey(key) && key != InventoryLabel(uid) {
+			return true
+		}
+	}
+	return false
+}
+
+// release removes the owner reference and the inventory label and annotation of the CR
+// from obj, leaving it to its other owners. The patch fails with a conflict if obj changed
+// since it was listed.
+func (i *ControllerActionRunner) release(obj client.Object) error {
+	i.track(VerbUpdate, obj)
+	uid := i.cr.GetUID()
+
+	released := obj.DeepCopyObject().(client.Object)
+	var refs []metav1.OwnerReference
+	for _, ref := range obj.GetOwnerReferences() {
+		if ref.UID != uid {
+			refs = append(refs, ref)
+		}
+	}
+	released.SetOwnerReferences(refs)
+	labels := released.GetLabels()
+	delete(labels, InventoryLabel(uid))
+	released.SetLabels(labels)
+	annotations := released.GetAnnotations()
+	delete(annotations, InventoryLabel(uid))
+	released.SetAnnotations(annotations)
+
+	patch := client.MergeFromWithOptions(obj, client.MergeFromWithOptimisticLock{})
+	if i.dryRun != "" {
+		return i.planWrite(VerbUpdate, released, func(planned client.Object) error {
+			return i.client.Patch(i.context, planned, patch, client.DryRunAll)
+		})
+	}
+
+	err := i.client.Patch(i.context, released, patch)
+	if err != nil {
+		log.Error(err, "Error releasing object")
+		return err
+	}
+
+	return nil
+}
+
+// stampInventory adds obj to the inventory of the CR, so it can be pruned once it is no
+// longer part of the desired state
+func (i *ControllerActionRunner) stampInventory(obj client.Object) {
+	uid := i.cr.GetUID()
+	if uid == "" || obj.GetUID() == uid {
+		return
+	}
+
+	labels := obj.GetLabels()
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	labels[InventoryLabel(uid)] = inventoryLabelValue
+	obj.SetLabels(labels)
+
+	annotations := obj.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[InventoryLabel(uid)] = objectReferenceString(i.objectReference(i.cr))
+	obj.SetAnnotations(annotations)
+}
+
+// pruneResources deletes the inventoried resources that are not in report and adds
+// an entry to report for each of them
+func (i *ControllerActionRunner) pruneResources(report RunReport) (RunReport, error) {
+	uid := i.cr.GetUID()
+	if uid == "" {
+		return report, nil
+	}
+
+	keep := map[string]bool{}
+	for _, entry := range report {
+		if entry.Object != nil && entry.Verb != VerbDelete {
+			gv, _ := schema.ParseGroupVersion(entry.Object.APIVersion)
+			keep[inventoryKey(gv.WithKind(entry.Object.Kind), entry.Object.Namespace, entry.Object.Name)] = true
+		}
+	}
+
+	pruner := i
+	if i.prune.DryRun && i.dryRun == "" {
+		pruner = &ControllerActionRunner{}
+		*pruner = *i
+		pruner.dryRun = DryRunClient
+		pruner.plan = &planRecorder{}
+	}
+
+	var failures []*ActionError
+	for _, gvk := range i.prune.GroupVersionKinds {
+		list := &metav1.PartialObjectMetadataList{}
+		list.SetGroupVersionKind(gvk.GroupVersion().WithKind(gvk.Kind + "List"))
+		err := i.client.List(i.context, list, client.HasLabels{InventoryLabel(uid)})
+		if err != nil {
+			log.Error(err, "Error listing inventoried objects", "kind", gvk.String())
+			return report, err
+		}
+
+		for n := range list.Items {
+			obj := &list.Items[n]
+			obj.SetGroupVersionKind(gvk)
+			if keep[inventoryKey(gvk, obj.GetNamespace(), obj.GetName())] {
+				continue
+			}
+
+			index := len(report)
+			report = append(report, ActionReport{Index: index, ID: "prune"})
+			var action ControllerAction = pruneAction{
+				Ref: obj,
+				Msg: fmt.Sprintf("Prune %s %s/%s", gvk.Kind, obj.GetNamespace(), obj.GetName()),
+			}
+			// Resources shared with other owners are only deleted by their last owner
+			if hasOtherOwners(obj, uid) {
+				action = releaseAction{
+					Ref: obj,
+					Msg: fmt.Sprintf("Release %s %s/%s", gvk.Kind, obj.GetNamespace(), obj.GetName()),
+				}
+			}
+			result := pruner.runAction(index, action)
+			pruner.record(report, result)
+			if result.err != nil {
+				if i.runMode != RunModeContinueOnError {
+					return report, result.err
+				}
+				failures = append(failures, i.actionError(result, "prune", nil))
+			}
+		}
+	}
+
+	return report, newAggregateActionError(failures)
+}
+
+func inventoryKey(gvk schema.GroupVersionKind, namespace string, name string) string {
+	return fmt.Sprintf("%s/%s/%s/%s", gvk.Group, gvk.Kind, namespace, name)
+}
@@ -0,0 +1,155 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package actions
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	apiErrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestPrune(t *testing.T) {
+	scheme := newTestScheme()
+	owner := newTestOwner()
+	unrelated := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "unrelated", Namespace: "test"}}
+	cl := fake.NewClientBuilder().WithScheme(scheme).WithObjects(owner, unrelated).Build()
+
+	newConfigMap := func(name string) *corev1.ConfigMap {
+		return &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "test"}}
+	}
+	prune := PruneConfig{GroupVersionKinds: []schema.GroupVersionKind{corev1.SchemeGroupVersion.WithKind("ConfigMap")}}
+
+	runner := NewControllerActionRunner(context.TODO(), cl, scheme, owner, WithPrune(prune))
+	_, err := runner.RunAll(DesiredResourceState{
+		GenericCreateAction{Ref: newConfigMap("kept")},
+		GenericCreateAction{Ref: newConfigMap("dropped")},
+	})
+	assert.NoError(t, err)
+
+	live := &corev1.ConfigMap{}
+	assert.NoError(t, cl.Get(context.TODO(), client.ObjectKey{Namespace: "test", Name: "dropped"}, live))
+	assert.Equal(t, "true", live.Labels[InventoryLabel("owner-uid")])
+	assert.Equal(t, "ConfigMap test/owner", live.Annotations[InventoryLabel("owner-uid")])
+
+	desiredState := DesiredResourceState{
+		GenericReconcileAction{Ref: newConfigMap("kept")},
+	}
+
+	prune.DryRun = true
+	report, err := NewControllerActionRunner(context.TODO(), cl, scheme, owner, WithPrune(prune)).RunAll(desiredState)
+	assert.NoError(t, err)
+	assert.Len(t, report, 2)
+	assert.Equal(t, OutcomePlanned, report[1].Outcome)
+	assert.NoError(t, cl.Get(context.TODO(), client.ObjectKey{Namespace: "test", Name: "dropped"}, live))
+
+	prune.DryRun = false
+	report, err = NewControllerActionRunner(context.TODO(), cl, scheme, owner, WithPrune(prune)).RunAll(desiredState)
+	assert.NoError(t, err)
+	assert.Len(t, report, 2)
+	assert.Equal(t, VerbDelete, report[1].Verb)
+	assert.Equal(t, "dropped", report[1].Object.Name)
+
+	err = cl.Get(context.TODO(), client.ObjectKey{Namespace: "test", Name: "dropped"}, live)
+	assert.True(t, apiErrors.IsNotFound(err))
+	assert.NoError(t, cl.Get(context.TODO(), client.ObjectKey{Namespace: "test", Name: "kept"}, live))
+	assert.NoError(t, cl.Get(context.TODO(), client.ObjectKey{Namespace: "test", Name: "unrelated"}, live))
+}
+
+func TestPruneSharedResource(t *testing.T) {
+	scheme := newTestScheme()
+	ownerA := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "a", Namespace: "test", UID: "a-uid"}}
+	ownerB := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "b", Namespace: "test", UID: "b-uid"}}
+	cl := fake.NewClientBuilder().WithScheme(scheme).WithObjects(ownerA, ownerB).Build()
+	prune := PruneConfig{GroupVersionKinds: []schema.GroupVersionKind{corev1.SchemeGroupVersion.WithKind("Secret")}}
+
+	desiredState := func() DesiredResourceState {
+		return DesiredResourceState{GenericReconcileAction{
+			Ref:       &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "shared", Namespace: "test"}},
+			Ownership: OwnershipOwner,
+		}}
+	}
+	for _, owner := range []*corev1.ConfigMap{ownerA, ownerB} {
+		_, err := NewControllerActionRunner(context.TODO(), cl, scheme, owner, WithPrune(prune)).RunAll(desiredState())
+		assert.NoError(t, err)
+	}
+
+	live := &corev1.Secret{}
+	key := client.ObjectKey{Namespace: "test", Name: "shared"}
+	assert.NoError(t, cl.Get(context.TODO(), key, live))
+	assert.Len(t, live.OwnerReferences, 2)
+	assert.Equal(t, "true", live.Labels[InventoryLabel("a-uid")])
+	assert.Equal(t, "true", live.Labels[InventoryLabel("b-uid")])
+
+	// A no longer wants the secret, but B, which wrote it last, still owns it
+	report, err := NewControllerActionRunner(context.TODO(), cl, scheme, ownerA, WithPrune(prune)).RunAll(nil)
+	assert.NoError(t, err)
+	assert.Len(t, report, 1)
+	assert.Equal(t, VerbUpdate, report[0].Verb)
+
+	assert.NoError(t, cl.Get(context.TODO(), key, live))
+	assert.Len(t, live.OwnerReferences, 1)
+	assert.Equal(t, ownerB.UID, live.OwnerReferences[0].UID)
+	assert.NotContains(t, live.Labels, InventoryLabel("a-uid"))
+	assert.NotContains(t, live.Annotations, InventoryLabel("a-uid"))
+	assert.Equal(t, "true", live.Labels[InventoryLabel("b-uid")])
+
+	// B is the last owner, so it deletes the secret
+	report, err = NewControllerActionRunner(context.TODO(), cl, scheme, ownerB, WithPrune(prune)).RunAll(nil)
+	assert.NoError(t, err)
+	assert.Len(t, report, 1)
+	assert.Equal(t, VerbDelete, report[0].Verb)
+
+	err = cl.Get(context.TODO(), key, live)
+	assert.True(t, apiErrors.IsNotFound(err))
+}
+
+func TestSharedResourceUnchanged(t *testing.T) {
+	scheme := newTestScheme()
+	ownerA := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "a", Namespace: "test", UID: "a-uid"}}
+	ownerB := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "b", Namespace: "test", UID: "b-uid"}}
+
+	newSecret := func() *corev1.Secret {
+		return &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "shared", Namespace: "test"},
+			StringData: map[string]string{"key": "value"},
+		}
+	}
+	actions := map[string]func() ControllerAction{
+		"reconcile": func() ControllerAction {
+			return GenericReconcileAction{Ref: newSecret(), Ownership: OwnershipOwner}
+		},
+		"update if changed": func() ControllerAction {
+			return GenericUpdateAction{Ref: newSecret(), Ownership: OwnershipOwner, SkipUnchanged: true}
+		},
+	}
+
+	for name, action := range actions {
+		t.Run(name, func(t *testing.T) {
+			cl := fake.NewClientBuilder().WithScheme(scheme).WithObjects(ownerA, ownerB, newSecret()).Build()
+
+			var report RunReport
+			for _, owner := range []*corev1.ConfigMap{ownerA, ownerB, ownerA} {
+				var err error
+				report, err = NewControllerActionRunner(context.TODO(), cl, scheme, owner).RunAll(DesiredResourceState{action()})
+				assert.NoError(t, err)
+			}
+			// The last writer does not remove A from the owners or the inventory, so A finds it unchanged
+			assert.Equal(t, OutcomeUnchanged, report[0].Outcome)
+
+			live := &corev1.Secret{}
+			assert.NoError(t, cl.Get(context.TODO(), client.ObjectKey{Namespace: "test", Name: "shared"}, live))
+			assert.Len(t, live.OwnerReferences, 2)
+			assert.Equal(t, "true", live.Labels[InventoryLabel("a-uid")])
+			assert.Equal(t, "true", live.Labels[InventoryLabel("b-uid")])
+		})
+	}
+}
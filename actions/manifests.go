@@ -0,0 +1,169 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package actions
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// ManifestLoader renders the YAML manifests of a file system, typically an embed.FS or
+// an os.DirFS, into a DesiredResourceState. Every manifest is a text/template and may
+// hold several documents separated by "---".
+type ManifestLoader struct {
+	// FS holds the manifests. Every .yaml and .yml file is loaded, in lexical order.
+	FS fs.FS
+	// Scheme decodes the known kinds into typed objects, other kinds are decoded as
+	// unstructured.Unstructured
+	Scheme *runtime.Scheme
+	// Funcs are added to the functions of every template
+	Funcs template.FuncMap
+	// Ownership and OwnerFallback are set on every action
+	Ownership     OwnershipMode
+	OwnerFallback bool
+}
+
+// Load renders every manifest against data, typically the CR, and returns a
+// GenericReconcileAction for each object. Errors name the manifest and the line, in the
+// template for render errors and in the rendered document for decode errors.
+func (l ManifestLoader) Load(data interface{}) (DesiredResourceState, error) {
+	var desiredState DesiredResourceState
+	err := fs.WalkDir(l.FS, ".", func(file string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() || (path.Ext(file) != ".yaml" && path.Ext(file) != ".yml") {
+			return nil
+		}
+
+		objects, err := l.loadFile(file, data)
+		if err != nil {
+			return err
+		}
+		for _, obj := range objects {
+			desiredState.AddAction(GenericReconcileAction{
+				Ref:           obj,
+				Msg:           fmt.Sprintf("%s %s from %s", obj.GetObjectKind().GroupVersionKind().Kind, client.ObjectKeyFromObject(obj), file),
+				Ownership:     l.Ownership,
+				OwnerFallback: l.OwnerFallback,
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return desiredState, nil
+}
+
+func (l ManifestLoader) loadFile(file string, data interface{}) ([]client.Object, error) {
+	content, err := fs.ReadFile(l.FS, file)
+	if err != nil {
+		return nil, err
+	}
+
+	// text/template errors name the template and line, so name templates after their file
+	tmpl, err := template.New(file).Option("missingkey=error").Funcs(l.Funcs).Parse(string(content))
+	if err != nil {
+		return nil, err
+	}
+	var rendered bytes.Buffer
+	err = tmpl.Execute(&rendered, data)
+	if err != nil {
+		return nil, err
+	}
+
+	var objects []client.Object
+	for _, document := range splitDocuments(rendered.Bytes()) {
+		obj, err := l.decode(document.content)
+		if err != nil {
+			return nil, errors.Errorf("%s:%d: %v", file, document.line, err)
+		}
+		if obj != nil {
+			objects = append(objects, obj)
+		}
+	}
+	return objects, nil
+}
+
+// decode returns the object in a YAML document, or nil if the document is empty
+func (l ManifestLoader) decode(document []byte) (client.Object, error) {
+	if len(bytes.TrimSpace(document)) == 0 {
+		return nil, nil
+	}
+	data, err := yaml.ToJSON(document)
+	if err != nil {
+		return nil, err
+	}
+	if string(data) == "null" {
+		return nil, nil
+	}
+
+	u := &unstructured.Unstructured{}
+	err = u.UnmarshalJSON(data)
+	if err != nil {
+		return nil, err
+	}
+
+	gvk := u.GroupVersionKind()
+	if l.Scheme == nil || !l.Scheme.Recognizes(gvk) {
+		return u, nil
+	}
+	typed, err := l.Scheme.New(gvk)
+	if err != nil {
+		return nil, err
+	}
+	err = runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, typed)
+	if err != nil {
+		return nil, err
+	}
+	obj, ok := typed.(client.Object)
+	if !ok {
+		return nil, errors.Errorf("%v is not an object", gvk)
+	}
+	return obj, nil
+}
+
+type manifestDocument struct {
+	// line the document starts at, counted from 1
+	line    int
+	content []byte
+}
+
+// splitDocuments splits a multi-document YAML stream on "---" lines, the way
+// yaml.YAMLReader does, and remembers where every document starts
+func splitDocuments(stream []byte) []manifestDocument {
+	documents := []manifestDocument{{line: 1}}
+	scanner := bufio.NewScanner(bytes.NewReader(stream))
+	scanner.Buffer(nil, len(stream)+1)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := scanner.Text()
+		if strings.HasPrefix(text, "---") {
+			trimmed := strings.TrimSpace(text[3:])
+			if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+				documents = append(documents, manifestDocument{line: line + 1})
+				continue
+			}
+		}
+		current := &documents[len(documents)-1]
+		current.content = append(current.content, text...)
+		current.content = append(current.content, '\n')
+	}
+	return documents
+}
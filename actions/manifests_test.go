@@ -0,0 +1,72 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package actions
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestManifestLoader(t *testing.T) {
+	loader := ManifestLoader{
+		FS: fstest.MapFS{
+			"config.yaml": {Data: []byte(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: {{ .Name }}-config
+  namespace: {{ .Namespace }}
+data:
+  key: value
+---
+# only a comment
+---
+apiVersion: example.com/v1
+kind: Widget
+metadata:
+  name: {{ .Name }}-widget
+  namespace: {{ .Namespace }}
+`)},
+			"README.md": {Data: []byte("not a manifest")},
+		},
+		Scheme: newTestScheme(),
+	}
+
+	desiredState, err := loader.Load(newTestOwner())
+	assert.NoError(t, err)
+	assert.Len(t, desiredState, 2)
+
+	configMap := desiredState[0].(GenericReconcileAction)
+	assert.IsType(t, &corev1.ConfigMap{}, configMap.Ref)
+	assert.Equal(t, "owner-config", configMap.Ref.GetName())
+	assert.Equal(t, "value", configMap.Ref.(*corev1.ConfigMap).Data["key"])
+	assert.Equal(t, "ConfigMap test/owner-config from config.yaml", configMap.Msg)
+
+	widget := desiredState[1].(GenericReconcileAction)
+	assert.IsType(t, &unstructured.Unstructured{}, widget.Ref)
+	assert.Equal(t, "owner-widget", widget.Ref.GetName())
+}
+
+func TestManifestLoaderErrors(t *testing.T) {
+	loader := ManifestLoader{
+		FS: fstest.MapFS{
+			"render.yaml": {Data: []byte("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: {{ .Missing }}\n")},
+		},
+		Scheme: newTestScheme(),
+	}
+	_, err := loader.Load(map[string]string{})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "render.yaml:4:")
+
+	loader.FS = fstest.MapFS{
+		"decode.yaml": {Data: []byte("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: a\n---\nmetadata:\n  name: b\n")},
+	}
+	_, err = loader.Load(nil)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "decode.yaml:6:")
+}
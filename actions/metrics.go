@@ -0,0 +1,67 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package actions
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const (
+	metricsNamespace = "operator_utils"
+)
+
+var (
+	actionsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "actions_total",
+			Help:      "Total number of actions run by ControllerActionRunner per verb, kind and outcome",
+		},
+		[]string{"verb", "group", "version", "kind", "outcome"},
+	)
+
+	actionDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "action_duration_seconds",
+			Help:      "Time taken by the actions run by ControllerActionRunner per verb and kind",
+			Buckets:   prometheus.DefBuckets,
+		},
+		[]string{"verb", "group", "version", "kind"},
+	)
+
+	reconcileOutcomesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "reconcile_outcomes_total",
+			Help:      "Total number of reconciles finished by ManageError and ManageSuccess per result and status reason",
+		},
+		[]string{"result", "reason"},
+	)
+)
+
+func init() {
+	// Registered with controller-runtime so the metrics are served by the manager
+	metrics.Registry.MustRegister(actionsTotal, actionDuration, reconcileOutcomesTotal)
+}
+
+// observeAction records the metrics of an action that ran
+func observeAction(entry ActionReport) {
+	var gvk schema.GroupVersionKind
+	if entry.Object != nil {
+		gvk = schema.FromAPIVersionAndKind(entry.Object.APIVersion, entry.Object.Kind)
+	}
+	verb := string(entry.Verb)
+
+	actionsTotal.WithLabelValues(verb, gvk.Group, gvk.Version, gvk.Kind, string(entry.Outcome)).Inc()
+	actionDuration.WithLabelValues(verb, gvk.Group, gvk.Version, gvk.Kind).Observe(entry.Duration.Seconds())
+}
+
+// observeReconcile records the outcome of a reconcile, result is either "error" or "success"
+func observeReconcile(result string, reason string) {
+	reconcileOutcomesTotal.WithLabelValues(result, reason).Inc()
+}
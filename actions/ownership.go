@@ -0,0 +1,259 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package actions
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	apiErrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// FallbackKindsAnnotation lists, on the CR, the kinds of the resources it owns through
+// the inventory label only, so ManageDeletion can find and delete them
+const FallbackKindsAnnotation = "operator-utils.jeesmon.github.io/fallback-owned-kinds"
+
+// OwnershipMode selects the owner reference the runner sets on a resource
+type OwnershipMode string
+
+const (
+	// OwnershipController makes the CR the controller owner of the resource. It is the
+	// default when no mode is set.
+	OwnershipController OwnershipMode = "Controller"
+	// OwnershipOwner adds the CR as a non-controller owner, next to the other owners of a
+	// resource shared by several CRs, so it is only garbage collected with its last owner
+	OwnershipOwner OwnershipMode = "Owner"
+	// OwnershipNone leaves the owner references of the resource alone
+	OwnershipNone OwnershipMode = "None"
+)
+
+// Ownership selects how the runner links a written resource to the CR
+type Ownership struct {
+	Mode OwnershipMode
+	// Fallback handles resources that cannot have the CR as owner reference, because
+	// they are cluster-scoped or in another namespace than the CR. Instead of failing,
+	// they are only linked with the inventory label and annotation, and ManageDeletion
+	// deletes them, or releases the ones shared with other CRs, before removing the
+	// finalizer of the CR.
+	Fallback bool
+}
+
+// setOwner links obj to the CR with the inventory label and, unless ownership is
+// OwnershipNone or falls back, an owner reference. The owner references of the other
+// owners of obj are kept.
+func (i *ControllerActionRunner) setOwner(obj client.Object, ownership Ownership) error {
+	i.stampInventory(obj)
+	if ownership.Mode == OwnershipNone {
+		return nil
+	}
+	if ownership.Fallback && !canOwn(i.cr, obj) {
+		return i.recordFallbackKind(obj)
+	}
+
+	owner := i.cr.(metav1.Object)
+	resource := obj.(metav1.Object)
+
+	var err error
+	if ownership.Mode == OwnershipOwner {
+		err = controllerutil.SetOwnerReference(owner, resource, i.scheme)
+	} else {
+		err = controllerutil.SetControllerReference(owner, resource, i.scheme)
+	}
+	if err != nil {
+		log.Error(err, "Error setting owner reference")
+		return err
+	}
+
+	return nil
+}
+
+// mergeOwnerReferences adds the owner references and inventory labels and annotations of
+// live to obj, so writing obj keeps the other owners of a shared resource. References set
+// on obj replace the ones of live with the same UID, and the order of live is kept so
+// unchanged objects compare equal.
+func mergeOwnerReferences(obj client.Object, live client.Object) {
+	merged := append([]metav1.OwnerReference{}, live.GetOwnerReferences()...)
+	for _, ref := range obj.GetOwnerReferences() {
+		found := false
+		for n := range merged {
+			if merged[n].UID == ref.UID {
+				merged[n] = ref
+				found = true
+				break
+			}
+		}
+		if !found {
+			merged = append(merged, ref)
+		}
+	}
+	if len(merged) > 0 {
+		obj.SetOwnerReferences(merged)
+	}
+
+	obj.SetLabels(mergeInventory(obj.GetLabels(), live.GetLabels()))
+	obj.SetAnnotations(mergeInventory(obj.GetAnnotations(), live.GetAnnotations()))
+}
+
+// mergeInventory adds the inventory keys of live that are missing from values
+func mergeInventory(values map[string]string, live map[string]string) map[string]string {
+	for key, value := range live {
+		if !isInventoryKey(key) {
+			continue
+		}
+		if _, found := values[key]; found {
+			continue
+		}
+		if values == nil {
+			values = map[string]string{}
+		}
+		values[key] = value
+	}
+	return values
+}
+
+// mergeLiveOwnerReferences reads the live version of obj and merges its owner references
+// into obj. A missing live object is left for the write to report.
+func (i *ControllerActionRunner) mergeLiveOwnerReferences(obj client.Object) error {
+	live := obj.DeepCopyObject().(client.Object)
+	err := i.client.Get(i.context, client.ObjectKeyFromObject(obj), live)
+	if apiErrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		log.Error(err, "Error reading object")
+		return err
+	}
+	mergeOwnerReferences(obj, live)
+	return nil
+}
+
+// canOwn reports whether owner may be an owner reference of obj. Cluster-scoped owners
+// can own anything, namespaced owners only resources in their own namespace.
+func canOwn(owner client.Object, obj client.Object) bool {
+	return owner.GetNamespace() == "" || owner.GetNamespace() == obj.GetNamespace()
+}
+
+// recordFallbackKind adds the kind of obj to the FallbackKindsAnnotation of the CR
+func (i *ControllerActionRunner) recordFallbackKind(obj client.Object) error {
+	gvk, err := apiutil.GVKForObject(obj, i.scheme)
+	if err != nil {
+		log.Error(err, "Error resolving object kind")
+		return err
+	}
+	if i.dryRun != "" {
+		return nil
+	}
+
+	i.crLock.Lock()
+	defer i.crLock.Unlock()
+
+	kinds := fallbackKinds(i.cr)
+	for _, kind := range kinds {
+		if kind == gvk {
+			return nil
+		}
+	}
+	kinds = append(kinds, gvk)
+
+	// Patch a copy, so the pending changes of the caller to the CR are not overwritten
+	// with the response
+	patched := i.cr.DeepCopyObject().(client.Object)
+	patch := client.MergeFrom(i.cr.DeepCopyObject().(client.Object))
+	setFallbackKinds(patched, kinds)
+	err = i.client.Patch(i.context, patched, patch)
+	if err != nil {
+		log.Error(err, "Error recording fallback owned kind")
+		return err
+	}
+	setFallbackKinds(i.cr, kinds)
+
+	return nil
+}
+
+// fallbackKinds parses the FallbackKindsAnnotation of cr. Kinds are written as
+// group/version/Kind, or version/Kind for the core group.
+func fallbackKinds(cr client.Object) []schema.GroupVersionKind {
+	value := cr.GetAnnotations()[FallbackKindsAnnotation]
+	if value == "" {
+		return nil
+	}
+
+	var kinds []schema.GroupVersionKind
+	for _, kind := range strings.Split(value, ",") {
+		separator := strings.LastIndex(kind, "/")
+		if separator < 0 {
+			continue
+		}
+		gv, err := schema.ParseGroupVersion(kind[:separator])
+		if err != nil {
+			continue
+		}
+		kinds = append(kinds, gv.WithKind(kind[separator+1:]))
+	}
+	return kinds
+}
+
+func setFallbackKinds(cr client.Object, kinds []schema.GroupVersionKind) {
+	values := make([]string, len(kinds))
+	for n, kind := range kinds {
+		values[n] = kind.GroupVersion().String() + "/" + kind.Kind
+	}
+	sort.Strings(values)
+
+	annotations := cr.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[FallbackKindsAnnotation] = strings.Join(values, ",")
+	cr.SetAnnotations(annotations)
+}
+
+// fallbackCleanupState returns an action deleting, or releasing when it is shared, each
+// resource the CR owns through the inventory label only
+func (i *ControllerActionRunner) fallbackCleanupState() (DesiredResourceState, error) {
+	uid := i.cr.GetUID()
+	if uid == "" {
+		return nil, nil
+	}
+
+	var desiredState DesiredResourceState
+	for _, gvk := range fallbackKinds(i.cr) {
+		list := &metav1.PartialObjectMetadataList{}
+		list.SetGroupVersionKind(gvk.GroupVersion().WithKind(gvk.Kind + "List"))
+		err := i.client.List(i.context, list, client.HasLabels{InventoryLabel(uid)})
+		if err != nil {
+			log.Error(err, "Error listing fallback owned objects", "kind", gvk.String())
+			return nil, err
+		}
+
+		for n := range list.Items {
+			obj := &list.Items[n]
+			obj.SetGroupVersionKind(gvk)
+			// Resources in the namespace of the CR are garbage collected through their owner reference
+			if obj.GetNamespace() == i.cr.GetNamespace() {
+				continue
+			}
+			// Resources shared with other owners are only deleted by their last owner
+			if hasOtherOwners(obj, uid) {
+				desiredState.AddAction(releaseAction{
+					Ref: obj,
+					Msg: fmt.Sprintf("Release %s %s/%s", gvk.Kind, obj.GetNamespace(), obj.GetName()),
+				})
+				continue
+			}
+			desiredState.AddAction(pruneAction{
+				Ref: obj,
+				Msg: fmt.Sprintf("Delete %s %s/%s", gvk.Kind, obj.GetNamespace(), obj.GetName()),
+			})
+		}
+	}
+	return desiredState, nil
+}
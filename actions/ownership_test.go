@@ -0,0 +1,110 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package actions
+
+import (
+	"context"
+	"testing"
+
+	conditions "github.com/openshift/custom-resource-status/conditions/v1"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apiErrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestOwnershipFallback(t *testing.T) {
+	scheme := newTestScheme()
+	const finalizer = "test/finalizer"
+	owner := newTestOwner()
+	owner.Finalizers = []string{finalizer}
+	cl := fake.NewClientBuilder().WithScheme(scheme).WithObjects(owner).Build()
+	assert.NoError(t, cl.Get(context.TODO(), client.ObjectKeyFromObject(owner), owner))
+	runner := NewControllerActionRunner(context.TODO(), cl, scheme, owner)
+
+	role := &rbacv1.ClusterRole{ObjectMeta: metav1.ObjectMeta{Name: "role"}}
+	_, err := GenericCreateAction{Ref: role.DeepCopy()}.Run(runner)
+	assert.Error(t, err)
+
+	desiredState := DesiredResourceState{
+		GenericCreateAction{Ref: role, OwnerFallback: true},
+		GenericCreateAction{
+			Ref:           &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "config", Namespace: "other"}},
+			OwnerFallback: true,
+		},
+		GenericCreateAction{
+			Ref:           &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "config", Namespace: "test"}},
+			OwnerFallback: true,
+		},
+	}
+	_, err = runner.RunAll(desiredState)
+	assert.NoError(t, err)
+
+	live := &rbacv1.ClusterRole{}
+	assert.NoError(t, cl.Get(context.TODO(), client.ObjectKeyFromObject(role), live))
+	assert.Empty(t, live.OwnerReferences)
+	assert.Equal(t, "true", live.Labels[InventoryLabel("owner-uid")])
+
+	// Resources the CR can own still get an owner reference
+	config := &corev1.ConfigMap{}
+	assert.NoError(t, cl.Get(context.TODO(), client.ObjectKey{Namespace: "test", Name: "config"}, config))
+	assert.Len(t, config.OwnerReferences, 1)
+
+	assert.NoError(t, cl.Get(context.TODO(), client.ObjectKeyFromObject(owner), owner))
+	assert.Equal(t, "rbac.authorization.k8s.io/v1/ClusterRole,v1/ConfigMap", owner.Annotations[FallbackKindsAnnotation])
+
+	now := metav1.Now()
+	owner.DeletionTimestamp = &now
+	assert.NoError(t, cl.Update(context.TODO(), owner))
+
+	var statusConditions []conditions.Condition
+	deleting, _, err := ManageDeletion(cl, scheme, context.TODO(), owner, &statusConditions, finalizer, nil)
+	assert.NoError(t, err)
+	assert.True(t, deleting)
+
+	err = cl.Get(context.TODO(), client.ObjectKeyFromObject(role), live)
+	assert.True(t, apiErrors.IsNotFound(err))
+	err = cl.Get(context.TODO(), client.ObjectKey{Namespace: "other", Name: "config"}, &corev1.ConfigMap{})
+	assert.True(t, apiErrors.IsNotFound(err))
+}
+
+func TestSharedOwnership(t *testing.T) {
+	scheme := newTestScheme()
+	cl := fake.NewClientBuilder().WithScheme(scheme).Build()
+	first := newTestOwner()
+	second := newTestOwner()
+	second.Name = "second"
+	second.UID = "second-uid"
+
+	newConfigMap := func() *corev1.ConfigMap {
+		return &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "shared", Namespace: "test"},
+			Data:       map[string]string{"key": "value"},
+		}
+	}
+
+	for _, owner := range []*corev1.ConfigMap{first, second, first} {
+		runner := NewControllerActionRunner(context.TODO(), cl, scheme, owner)
+		_, err := GenericReconcileAction{Ref: newConfigMap(), Ownership: OwnershipOwner}.Run(runner)
+		assert.NoError(t, err)
+	}
+
+	live := &corev1.ConfigMap{}
+	assert.NoError(t, cl.Get(context.TODO(), client.ObjectKey{Namespace: "test", Name: "shared"}, live))
+	assert.Len(t, live.OwnerReferences, 2)
+	assert.Equal(t, first.UID, live.OwnerReferences[0].UID)
+	assert.Equal(t, second.UID, live.OwnerReferences[1].UID)
+	assert.Nil(t, metav1.GetControllerOf(live))
+
+	// A plain update keeps the owners of the live object
+	runner := NewControllerActionRunner(context.TODO(), cl, scheme, second)
+	_, err := GenericUpdateAction{Ref: newConfigMap(), Ownership: OwnershipOwner}.Run(runner)
+	assert.NoError(t, err)
+	assert.NoError(t, cl.Get(context.TODO(), client.ObjectKey{Namespace: "test", Name: "shared"}, live))
+	assert.Len(t, live.OwnerReferences, 2)
+}
@@ -0,0 +1,264 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package actions
+
+import (
+	"sort"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	apiErrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+)
+
+// DryRunMode selects how a plan is computed
+type DryRunMode string
+
+const (
+	// DryRunServer sends every write to the API server with dryRun=All, so admission
+	// and defaulting are part of the plan
+	DryRunServer DryRunMode = "Server"
+	// DryRunClient only reads the live objects and simulates the writes in memory
+	DryRunClient DryRunMode = "Client"
+)
+
+// Verb is the kind of write an action makes
+type Verb string
+
+const (
+	VerbCreate Verb = "create"
+	VerbUpdate Verb = "update"
+	VerbApply  Verb = "apply"
+	VerbDelete Verb = "delete"
+	// VerbDeleteCollection deletes all resources of a kind matching a selector
+	VerbDeleteCollection Verb = "deletecollection"
+	// VerbRecreate deletes a resource and creates it again
+	VerbRecreate Verb = "recreate"
+	VerbNone     Verb = "none"
+)
+
+// FieldDiff is a single field that differs between the live and the planned object.
+// Old is nil for added fields and New is nil for removed fields.
+type FieldDiff struct {
+	Path string      `json:"path"`
+	Old  interface{} `json:"old,omitempty"`
+	New  interface{} `json:"new,omitempty"`
+}
+
+// PlannedAction is a write an action would make
+type PlannedAction struct {
+	Index      int         `json:"index"`
+	Msg        string      `json:"msg"`
+	Verb       Verb        `json:"verb"`
+	APIVersion string      `json:"apiVersion"`
+	Kind       string      `json:"kind"`
+	Namespace  string      `json:"namespace,omitempty"`
+	Name       string      `json:"name"`
+	Diff       []FieldDiff `json:"diff,omitempty"`
+}
+
+// Plan lists the writes a DesiredResourceState would make, in the order of the actions
+type Plan []PlannedAction
+
+type planRecorder struct {
+	sync.Mutex
+	actions Plan
+}
+
+func (r *planRecorder) add(action PlannedAction) {
+	r.Lock()
+	defer r.Unlock()
+	r.actions = append(r.actions, action)
+}
+
+// describe sets the message of the action at index once the action returned it
+func (r *planRecorder) describe(index int, msg string) {
+	r.Lock()
+	defer r.Unlock()
+	for n := range r.actions {
+		if r.actions[n].Index == index {
+			r.actions[n].Msg = msg
+		}
+	}
+}
+
+func (r *planRecorder) result() Plan {
+	r.Lock()
+	defer r.Unlock()
+	plan := make(Plan, len(r.actions))
+	copy(plan, r.actions)
+	sort.SliceStable(plan, func(a, b int) bool {
+		return plan[a].Index < plan[b].Index
+	})
+	return plan
+}
+
+// Plan runs every action of desiredState without changing the cluster and returns the
+// writes they would make. The returned plan holds the actions planned before an error.
+func (i *ControllerActionRunner) Plan(desiredState DesiredResourceState, mode DryRunMode) (Plan, error) {
+	planner := *i
+	planner.dryRun = mode
+	planner.plan = &planRecorder{}
+	// The report and related objects of the caller describe real runs only
+	planner.report = nil
+	planner.relatedObjects = nil
+
+	_, err := planner.RunAll(desiredState)
+	return planner.plan.result(), err
+}
+
+// planWrite records the difference between the live object and the result of write.
+// write is only called in server mode, on a copy of obj.
+func (i *ControllerActionRunner) planWrite(verb Verb, obj client.Object, write func(client.Object) error) error {
+	gvk, err := apiutil.GVKForObject(obj, i.scheme)
+	if err != nil {
+		log.Error(err, "Error resolving object kind")
+		return err
+	}
+
+	live := obj.DeepCopyObject().(client.Object)
+	err = i.client.Get(i.context, client.ObjectKeyFromObject(obj), live)
+	if apiErrors.IsNotFound(err) {
+		live = nil
+	} else if err != nil {
+		log.Error(err, "Error reading object")
+		return err
+	}
+
+	planned := obj.DeepCopyObject().(client.Object)
+	if i.dryRun == DryRunServer {
+		err = write(planned)
+		if err != nil {
+			log.Error(err, "Error running object dry-run")
+			return err
+		}
+	}
+
+	action := PlannedAction{
+		Index:      i.index,
+		Verb:       verb,
+		APIVersion: gvk.GroupVersion().String(),
+		Kind:       gvk.Kind,
+		Namespace:  obj.GetNamespace(),
+		Name:       obj.GetName(),
+	}
+	if verb != VerbDelete {
+		action.Diff, err = diffObjects(live, planned, i.dryRun == DryRunClient)
+		if err != nil {
+			log.Error(err, "Error comparing object")
+			return err
+		}
+	}
+	i.plan.add(action)
+
+	return nil
+}
+
+// planDeleteAllOf records the deletion of all resources of the kind of obj. The
+// matching resources are not listed, so the planned action has no name.
+func (i *ControllerActionRunner) planDeleteAllOf(obj client.Object, opts []client.DeleteAllOfOption) error {
+	gvk, err := apiutil.GVKForObject(obj, i.scheme)
+	if err != nil {
+		log.Error(err, "Error resolving object kind")
+		return err
+	}
+
+	if i.dryRun == DryRunServer {
+		err = i.client.DeleteAllOf(i.context, obj.DeepCopyObject().(client.Object), append(opts, client.DryRunAll)...)
+		if err != nil {
+			log.Error(err, "Error running object dry-run")
+			return err
+		}
+	}
+
+	options := &client.DeleteAllOfOptions{}
+	options.ApplyOptions(opts)
+	i.plan.add(PlannedAction{
+		Index:      i.index,
+		Verb:       VerbDeleteCollection,
+		APIVersion: gvk.GroupVersion().String(),
+		Kind:       gvk.Kind,
+		Namespace:  options.Namespace,
+	})
+	return nil
+}
+
+// planUnchanged records an object that is already in the desired state
+func (i *ControllerActionRunner) planUnchanged(obj client.Object) {
+	gvk, _ := apiutil.GVKForObject(obj, i.scheme)
+	i.plan.add(PlannedAction{
+		Index:      i.index,
+		Verb:       VerbNone,
+		APIVersion: gvk.GroupVersion().String(),
+		Kind:       gvk.Kind,
+		Namespace:  obj.GetNamespace(),
+		Name:       obj.GetName(),
+	})
+}
+
+// diffObjects lists the fields that differ between live and planned. When onlySet is
+// true, fields missing from planned are not reported as removed, because a simulated
+// write does not know the values the API server would fill in.
+func diffObjects(live client.Object, planned client.Object, onlySet bool) ([]FieldDiff, error) {
+	var liveFields map[string]interface{}
+	if live != nil {
+		var err error
+		liveFields, err = desiredStateFields(live)
+		if err != nil {
+			return nil, err
+		}
+	}
+	plannedFields, err := desiredStateFields(planned)
+	if err != nil {
+		return nil, err
+	}
+
+	return diffFields("", liveFields, plannedFields, onlySet), nil
+}
+
+func diffFields(path string, old interface{}, new interface{}, onlySet bool) []FieldDiff {
+	oldMap, oldIsMap := old.(map[string]interface{})
+	newMap, newIsMap := new.(map[string]interface{})
+	if (oldIsMap || old == nil) && (newIsMap || new == nil) && (oldIsMap || newIsMap) {
+		keys := map[string]bool{}
+		for key := range oldMap {
+			keys[key] = true
+		}
+		for key := range newMap {
+			keys[key] = true
+		}
+		sortedKeys := make([]string, 0, len(keys))
+		for key := range keys {
+			sortedKeys = append(sortedKeys, key)
+		}
+		sort.Strings(sortedKeys)
+
+		var diff []FieldDiff
+		for _, key := range sortedKeys {
+			newValue, found := newMap[key]
+			if onlySet && !found {
+				continue
+			}
+			diff = append(diff, diffFields(joinPath(path, key), oldMap[key], newValue, onlySet)...)
+		}
+		return diff
+	}
+
+	if onlySet && new == nil {
+		return nil
+	}
+	if equality.Semantic.DeepEqual(old, new) {
+		return nil
+	}
+	return []FieldDiff{{Path: path, Old: old, New: new}}
+}
+
+func joinPath(path string, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
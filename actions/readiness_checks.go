@@ -12,6 +12,7 @@ import (
 	appsv1 "k8s.io/api/apps/v1"
 	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
 	apiErrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	ctrl "sigs.k8s.io/controller-runtime"
@@ -24,10 +25,49 @@ const (
 
 type ResourceNotReadyError struct {
 	PartialObject client.Object
+	// Deleting is set when waiting for the resource to be gone
+	Deleting bool
 }
 
 func (e *ResourceNotReadyError) Error() string {
-	return fmt.Sprintf("%v/%v is not ready", e.PartialObject.GetNamespace(), e.PartialObject.GetName())
+	state := "is not ready"
+	if e.Deleting {
+		state = "is still being deleted"
+	}
+	return fmt.Sprintf("%v %s", describeObject(e.PartialObject), state)
+}
+
+// ResourceFailedError is returned by a readiness check when a resource cannot become
+// ready without a change, such as a Deployment that exceeded its progress deadline.
+// Reason and Message come from the failed condition.
+type ResourceFailedError struct {
+	PartialObject client.Object
+	Reason        string
+	Message       string
+}
+
+func (e *ResourceFailedError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("%v failed: %v", describeObject(e.PartialObject), e.Reason)
+	}
+	return fmt.Sprintf("%v failed: %v: %v", describeObject(e.PartialObject), e.Reason, e.Message)
+}
+
+func IsResourceFailedError(err error) bool {
+	var failed *ResourceFailedError
+	return errors.As(err, &failed)
+}
+
+// describeObject names obj in errors, with its kind when it is known
+func describeObject(obj client.Object) string {
+	name := obj.GetName()
+	if obj.GetNamespace() != "" {
+		name = obj.GetNamespace() + "/" + name
+	}
+	if kind := obj.GetObjectKind().GroupVersionKind().Kind; kind != "" {
+		return kind + " " + name
+	}
+	return name
 }
 
 func IsResourceFound(client client.Client, ctx context.Context, req ctrl.Request, instance client.Object) (bool, error) {
@@ -47,37 +87,161 @@ func IsResourceFound(client client.Client, ctx context.Context, req ctrl.Request
 }
 
 func IsResourceNotReadyError(err error) bool {
-	if err == nil {
-		return false
-	}
-	switch err.(type) {
-	case *ResourceNotReadyError:
-		return true
-	default:
-		return false
-	}
+	var notReady *ResourceNotReadyError
+	return errors.As(err, &notReady)
 }
 
+// IsDeploymentReady reports whether the latest generation of a Deployment is rolled out:
+// the controller observed it, all replicas are updated and available, and no old
+// replicas are left. A missed progress deadline or a replica failure is returned as a
+// ResourceFailedError.
 func IsDeploymentReady(resource *appsv1.Deployment) (bool, error) {
 	if resource == nil {
 		return false, nil
 	}
-	// A deployment has an array of conditions
+
+	// The status, including failed conditions, describes an older spec until the controller
+	// observed the latest generation
+	if resource.Status.ObservedGeneration < resource.Generation {
+		return false, nil
+	}
+
 	for _, condition := range resource.Status.Conditions {
-		// One failure condition exists, if this exists, return the Reason
-		if condition.Type == appsv1.DeploymentReplicaFailure {
-			return false, errors.Errorf(condition.Reason)
-			// A successful deployment will have the progressing condition type as true
-		} else if condition.Type == appsv1.DeploymentProgressing && condition.Status != ConditionStatusSuccess {
-			return false, nil
-			// A successful deployment will have the available condition type as true
-		} else if condition.Type == appsv1.DeploymentAvailable && condition.Status != ConditionStatusSuccess {
+		if condition.Type == appsv1.DeploymentReplicaFailure && condition.Status == ConditionStatusSuccess {
+			return false, &ResourceFailedError{PartialObject: resource, Reason: condition.Reason, Message: condition.Message}
+		}
+		if condition.Type == appsv1.DeploymentProgressing && condition.Reason == "ProgressDeadlineExceeded" {
+			return false, &ResourceFailedError{PartialObject: resource, Reason: condition.Reason, Message: condition.Message}
+		}
+	}
+
+	replicas := int32(1)
+	if resource.Spec.Replicas != nil {
+		replicas = *resource.Spec.Replicas
+	}
+	if resource.Status.UpdatedReplicas < replicas {
+		return false, nil
+	}
+	// Pods of the old replica sets are still terminating
+	if resource.Status.Replicas > resource.Status.UpdatedReplicas {
+		return false, nil
+	}
+	if resource.Status.AvailableReplicas < resource.Status.UpdatedReplicas {
+		return false, nil
+	}
+
+	for _, condition := range resource.Status.Conditions {
+		if condition.Type == appsv1.DeploymentAvailable && condition.Status != ConditionStatusSuccess {
 			return false, nil
 		}
 	}
 	return true, nil
 }
 
+// IsStatefulSetReady reports whether the latest generation of a StatefulSet is rolled out
+// and all its replicas are ready. With a partition, only the replicas at or above the
+// partition must be updated. With the OnDelete strategy, pods are only updated when they
+// are deleted, so updates are not waited for.
+func IsStatefulSetReady(resource *appsv1.StatefulSet) (bool, error) {
+	if resource == nil {
+		return false, nil
+	}
+
+	if resource.Status.ObservedGeneration == 0 || resource.Status.ObservedGeneration < resource.Generation {
+		return false, nil
+	}
+
+	replicas := int32(1)
+	if resource.Spec.Replicas != nil {
+		replicas = *resource.Spec.Replicas
+	}
+	if resource.Status.ReadyReplicas < replicas {
+		return false, nil
+	}
+
+	if resource.Spec.UpdateStrategy.Type == appsv1.OnDeleteStatefulSetStrategyType {
+		return true, nil
+	}
+	rollingUpdate := resource.Spec.UpdateStrategy.RollingUpdate
+	if rollingUpdate != nil && rollingUpdate.Partition != nil && *rollingUpdate.Partition > 0 {
+		return resource.Status.UpdatedReplicas >= replicas-*rollingUpdate.Partition, nil
+	}
+	return resource.Status.UpdateRevision == resource.Status.CurrentRevision, nil
+}
+
+// IsDaemonSetReady reports whether the latest generation of a DaemonSet is rolled out
+// and available on every node it is scheduled on. With the OnDelete strategy, pods are
+// only updated when they are deleted, so updates are not waited for.
+func IsDaemonSetReady(resource *appsv1.DaemonSet) (bool, error) {
+	if resource == nil {
+		return false, nil
+	}
+
+	if resource.Status.ObservedGeneration < resource.Generation {
+		return false, nil
+	}
+
+	if resource.Spec.UpdateStrategy.Type != appsv1.OnDeleteDaemonSetStrategyType &&
+		resource.Status.UpdatedNumberScheduled < resource.Status.DesiredNumberScheduled {
+		return false, nil
+	}
+	if resource.Status.NumberUnavailable > 0 || resource.Status.NumberAvailable < resource.Status.DesiredNumberScheduled {
+		return false, nil
+	}
+	return true, nil
+}
+
+// IsReplicaSetReady reports whether the controller observed the latest generation of a
+// ReplicaSet and all its replicas are available. A replica failure is returned as a
+// ResourceFailedError.
+func IsReplicaSetReady(resource *appsv1.ReplicaSet) (bool, error) {
+	if resource == nil {
+		return false, nil
+	}
+
+	for _, condition := range resource.Status.Conditions {
+		if condition.Type == appsv1.ReplicaSetReplicaFailure && condition.Status == ConditionStatusSuccess {
+			return false, &ResourceFailedError{PartialObject: resource, Reason: condition.Reason, Message: condition.Message}
+		}
+	}
+
+	if resource.Status.ObservedGeneration < resource.Generation {
+		return false, nil
+	}
+
+	replicas := int32(1)
+	if resource.Spec.Replicas != nil {
+		replicas = *resource.Spec.Replicas
+	}
+	return resource.Status.AvailableReplicas >= replicas, nil
+}
+
+// IsPodReady reports whether a Pod has the Ready condition, or completed successfully.
+// A failed Pod is returned as a ResourceFailedError.
+func IsPodReady(resource *corev1.Pod) (bool, error) {
+	if resource == nil {
+		return false, nil
+	}
+
+	switch resource.Status.Phase {
+	case corev1.PodSucceeded:
+		return true, nil
+	case corev1.PodFailed:
+		reason := resource.Status.Reason
+		if reason == "" {
+			reason = string(corev1.PodFailed)
+		}
+		return false, &ResourceFailedError{PartialObject: resource, Reason: reason, Message: resource.Status.Message}
+	}
+
+	for _, condition := range resource.Status.Conditions {
+		if condition.Type == corev1.PodReady {
+			return condition.Status == ConditionStatusSuccess, nil
+		}
+	}
+	return false, nil
+}
+
 func IsEndpointsReady(resource *corev1.Endpoints) (bool, error) {
 	if resource == nil {
 		return false, nil
@@ -92,6 +256,45 @@ func IsEndpointsReady(resource *corev1.Endpoints) (bool, error) {
 	return false, nil
 }
 
+// IsPersistentVolumeClaimReady reports whether a PersistentVolumeClaim is bound to a
+// volume. A claim whose volume was lost is returned as a ResourceFailedError.
+func IsPersistentVolumeClaimReady(resource *corev1.PersistentVolumeClaim) (bool, error) {
+	if resource == nil {
+		return false, nil
+	}
+
+	switch resource.Status.Phase {
+	case corev1.ClaimBound:
+		return true, nil
+	case corev1.ClaimLost:
+		return false, &ResourceFailedError{PartialObject: resource, Reason: string(corev1.ClaimLost), Message: "bound volume no longer exists"}
+	}
+	return false, nil
+}
+
+// IsServiceReady reports whether a Service of type LoadBalancer has an ingress address.
+// Services of the other types are ready once they exist.
+func IsServiceReady(resource *corev1.Service) (bool, error) {
+	if resource == nil {
+		return false, nil
+	}
+
+	if resource.Spec.Type != corev1.ServiceTypeLoadBalancer {
+		return true, nil
+	}
+	return len(resource.Status.LoadBalancer.Ingress) > 0, nil
+}
+
+// IsIngressReady reports whether the ingress controller published the load balancer
+// address of an Ingress
+func IsIngressReady(resource *networkingv1.Ingress) (bool, error) {
+	if resource == nil {
+		return false, nil
+	}
+
+	return len(resource.Status.LoadBalancer.Ingress) > 0, nil
+}
+
 func IsJobReady(resource *batchv1.Job) (bool, error) {
 	if resource == nil {
 		return false, nil
@@ -108,74 +311,184 @@ func IsJobReady(resource *batchv1.Job) (bool, error) {
 	return false, nil
 }
 
-func IsServiceMeshControlPlaneReady(resource *unstructured.Unstructured) (bool, error) {
+// IsRouteReady reports whether an OpenShift Route was admitted by at least one router.
+// A Route rejected by all the routers that processed it is returned as a
+// ResourceFailedError with the reason of the first rejection.
+func IsRouteReady(resource *unstructured.Unstructured) (bool, error) {
 	if resource == nil {
 		return false, nil
 	}
 
-	items, found, err := unstructured.NestedSlice(resource.Object, "status", "conditions")
-	if !found || err != nil {
-		return false, errors.Errorf("Status Conditions for ServiceMeshControlPlane is not found")
+	ingresses, _, err := unstructured.NestedSlice(resource.Object, "status", "ingress")
+	if err != nil {
+		return false, err
 	}
 
-	for _, item := range items {
-		condition, ok := item.(map[string]interface{})
+	var rejected map[string]interface{}
+	pending := false
+	for _, item := range ingresses {
+		ingress, ok := item.(map[string]interface{})
 		if !ok {
-			return false, errors.Errorf("Status Conditions for ServiceMeshControlPlane is not found")
+			continue
 		}
-
-		if condition["type"] == "Ready" && condition["reason"] == "ComponentsReady" {
+		admitted := findCondition(ingress, "Admitted")
+		if admitted == nil {
+			pending = true
+			continue
+		}
+		if admitted["status"] == ConditionStatusSuccess {
 			return true, nil
 		}
+		if rejected == nil {
+			rejected = admitted
+		}
 	}
 
+	// Routers still processing the Route may admit it
+	if rejected != nil && !pending {
+		return false, conditionFailedError(resource, rejected)
+	}
 	return false, nil
 }
 
-func IsServiceMeshMemberRollReady(resource *unstructured.Unstructured) (bool, error) {
+// IsCustomResourceDefinitionReady reports whether the API server accepted the names of a
+// CustomResourceDefinition and serves it. Conflicting names are returned as a
+// ResourceFailedError.
+func IsCustomResourceDefinitionReady(resource *unstructured.Unstructured) (bool, error) {
 	if resource == nil {
 		return false, nil
 	}
 
-	items, found, err := unstructured.NestedSlice(resource.Object, "status", "conditions")
-	if !found || err != nil {
-		return false, errors.Errorf("Status Conditions for ServiceMeshMemberRoll is not found")
+	namesAccepted := findCondition(resource.Object, "NamesAccepted", "status")
+	if namesAccepted == nil {
+		return false, nil
+	}
+	if namesAccepted["status"] != ConditionStatusSuccess {
+		return false, conditionFailedError(resource, namesAccepted)
 	}
 
+	established := findCondition(resource.Object, "Established", "status")
+	return established != nil && established["status"] == ConditionStatusSuccess, nil
+}
+
+// findCondition returns the condition of conditionType in the conditions list found at
+// fields of obj, or nil
+func findCondition(obj map[string]interface{}, conditionType string, fields ...string) map[string]interface{} {
+	items, _, _ := unstructured.NestedSlice(obj, append(fields, "conditions")...)
 	for _, item := range items {
 		condition, ok := item.(map[string]interface{})
-		if !ok {
-			return false, errors.Errorf("Status Conditions for ServiceMeshMemberRoll is not found")
+		if ok && condition["type"] == conditionType {
+			return condition
 		}
+	}
+	return nil
+}
 
-		if condition["type"] == "Ready" && condition["status"] == ConditionStatusSuccess {
-			return true, nil
-		}
+func conditionFailedError(resource client.Object, condition map[string]interface{}) error {
+	reason, _, _ := unstructured.NestedString(condition, "reason")
+	if reason == "" {
+		reason, _, _ = unstructured.NestedString(condition, "type")
 	}
+	message, _, _ := unstructured.NestedString(condition, "message")
+	return &ResourceFailedError{PartialObject: resource, Reason: reason, Message: message}
+}
 
-	return false, nil
+// ConditionMatch selects status conditions by type, and by status and reason when they
+// are set. A match with neither status nor reason selects conditions with status True.
+type ConditionMatch struct {
+	Type   string
+	Status string
+	Reason string
 }
 
-func IsServiceMeshMemberReady(resource *unstructured.Unstructured) (bool, error) {
+func (m ConditionMatch) matches(condition map[string]interface{}) bool {
+	if condition["type"] != m.Type {
+		return false
+	}
+	if m.Status == "" && m.Reason == "" {
+		return condition["status"] == ConditionStatusSuccess
+	}
+	if m.Status != "" && condition["status"] != m.Status {
+		return false
+	}
+	return m.Reason == "" || condition["reason"] == m.Reason
+}
+
+// ConditionReadiness checks the readiness of any resource reporting status.conditions,
+// such as cert-manager Certificates, Knative Services or OLM ClusterServiceVersions
+type ConditionReadiness struct {
+	// Ready is the condition the resource has once it is ready
+	Ready ConditionMatch
+	// ObservedGeneration waits for the controller of the resource to observe its latest
+	// generation, read from the observedGeneration of the Ready condition or of the status
+	ObservedGeneration bool
+	// Failed are the conditions the resource has when it cannot become ready, returned as
+	// a ResourceFailedError with the reason and message of the condition
+	Failed []ConditionMatch
+}
+
+// IsReady reports whether resource has the Ready condition. Resources without conditions
+// are not ready yet.
+func (r ConditionReadiness) IsReady(resource *unstructured.Unstructured) (bool, error) {
 	if resource == nil {
 		return false, nil
 	}
 
-	items, found, err := unstructured.NestedSlice(resource.Object, "status", "conditions")
-	if !found || err != nil {
-		return false, errors.Errorf("Status Conditions for ServiceMeshMember is not found")
+	items, _, err := unstructured.NestedSlice(resource.Object, "status", "conditions")
+	if err != nil {
+		return false, errors.Wrapf(err, "Status Conditions for %v are invalid", resource.GetKind())
 	}
 
+	var ready map[string]interface{}
 	for _, item := range items {
 		condition, ok := item.(map[string]interface{})
 		if !ok {
-			return false, errors.Errorf("Status Conditions for ServiceMeshMember is not found")
+			return false, errors.Errorf("Status Conditions for %v are invalid", resource.GetKind())
+		}
+		for _, failed := range r.Failed {
+			if failed.matches(condition) {
+				return false, conditionFailedError(resource, condition)
+			}
 		}
+		if ready == nil && r.Ready.matches(condition) {
+			ready = condition
+		}
+	}
+	if ready == nil {
+		return false, nil
+	}
 
-		if condition["type"] == "Ready" && condition["status"] == ConditionStatusSuccess {
-			return true, nil
+	if r.ObservedGeneration {
+		observedGeneration, found, _ := unstructured.NestedInt64(ready, "observedGeneration")
+		if !found {
+			observedGeneration, found, _ = unstructured.NestedInt64(resource.Object, "status", "observedGeneration")
+		}
+		if !found || observedGeneration < resource.GetGeneration() {
+			return false, nil
 		}
 	}
+	return true, nil
+}
 
-	return false, nil
+// Check returns r as a ReadinessCheck, to register it in a ReadinessRegistry
+func (r ConditionReadiness) Check() ReadinessCheck {
+	return unstructuredCheck(r.IsReady)
+}
+
+var (
+	serviceMeshControlPlaneReadiness = ConditionReadiness{Ready: ConditionMatch{Type: "Ready", Reason: "ComponentsReady"}}
+	serviceMeshMemberRollReadiness   = ConditionReadiness{Ready: ConditionMatch{Type: "Ready", Status: ConditionStatusSuccess}}
+	serviceMeshMemberReadiness       = ConditionReadiness{Ready: ConditionMatch{Type: "Ready", Status: ConditionStatusSuccess}}
+)
+
+func IsServiceMeshControlPlaneReady(resource *unstructured.Unstructured) (bool, error) {
+	return serviceMeshControlPlaneReadiness.IsReady(resource)
+}
+
+func IsServiceMeshMemberRollReady(resource *unstructured.Unstructured) (bool, error) {
+	return serviceMeshMemberRollReadiness.IsReady(resource)
+}
+
+func IsServiceMeshMemberReady(resource *unstructured.Unstructured) (bool, error) {
+	return serviceMeshMemberReadiness.IsReady(resource)
 }
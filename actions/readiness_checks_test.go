@@ -0,0 +1,432 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package actions
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func newTestDeployment(generation int64, status appsv1.DeploymentStatus) *appsv1.Deployment {
+	replicas := int32(2)
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "test", Generation: generation},
+		Spec:       appsv1.DeploymentSpec{Replicas: &replicas},
+		Status:     status,
+	}
+}
+
+func TestIsDeploymentReady(t *testing.T) {
+	tests := []struct {
+		name       string
+		deployment *appsv1.Deployment
+		ready      bool
+	}{
+		{
+			name:       "rolled out",
+			deployment: newTestDeployment(2, appsv1.DeploymentStatus{ObservedGeneration: 2, Replicas: 2, UpdatedReplicas: 2, AvailableReplicas: 2}),
+			ready:      true,
+		},
+		{
+			name:       "generation not observed",
+			deployment: newTestDeployment(3, appsv1.DeploymentStatus{ObservedGeneration: 2, Replicas: 2, UpdatedReplicas: 2, AvailableReplicas: 2}),
+		},
+		{
+			name:       "replicas not updated",
+			deployment: newTestDeployment(2, appsv1.DeploymentStatus{ObservedGeneration: 2, Replicas: 2, UpdatedReplicas: 1, AvailableReplicas: 2}),
+		},
+		{
+			name:       "old replicas terminating",
+			deployment: newTestDeployment(2, appsv1.DeploymentStatus{ObservedGeneration: 2, Replicas: 3, UpdatedReplicas: 2, AvailableReplicas: 3}),
+		},
+		{
+			name:       "updated replicas not available",
+			deployment: newTestDeployment(2, appsv1.DeploymentStatus{ObservedGeneration: 2, Replicas: 2, UpdatedReplicas: 2, AvailableReplicas: 1}),
+		},
+		{
+			name: "not available",
+			deployment: newTestDeployment(2, appsv1.DeploymentStatus{ObservedGeneration: 2, Replicas: 2, UpdatedReplicas: 2, AvailableReplicas: 2,
+				Conditions: []appsv1.DeploymentCondition{{Type: appsv1.DeploymentAvailable, Status: corev1.ConditionFalse}}}),
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ready, err := IsDeploymentReady(test.deployment)
+			assert.NoError(t, err)
+			assert.Equal(t, test.ready, ready)
+		})
+	}
+}
+
+func TestIsDeploymentReadyFailed(t *testing.T) {
+	deployment := newTestDeployment(2, appsv1.DeploymentStatus{ObservedGeneration: 2, Replicas: 2, UpdatedReplicas: 1, AvailableReplicas: 1,
+		Conditions: []appsv1.DeploymentCondition{{
+			Type:    appsv1.DeploymentProgressing,
+			Status:  corev1.ConditionFalse,
+			Reason:  "ProgressDeadlineExceeded",
+			Message: `ReplicaSet "app-5d4f" has timed out progressing.`,
+		}}})
+
+	ready, err := IsDeploymentReady(deployment)
+	assert.False(t, ready)
+	assert.True(t, IsResourceFailedError(err))
+	assert.EqualError(t, err, `test/app failed: ProgressDeadlineExceeded: ReplicaSet "app-5d4f" has timed out progressing.`)
+
+	failed := err.(*ResourceFailedError)
+	assert.Equal(t, "ProgressDeadlineExceeded", failed.Reason)
+
+	// A fixed Deployment is rolling out until the controller observed it and updated the condition
+	deployment.Generation = 3
+	ready, err = IsDeploymentReady(deployment)
+	assert.NoError(t, err)
+	assert.False(t, ready)
+}
+
+func TestIsStatefulSetReady(t *testing.T) {
+	newStatefulSet := func(strategy appsv1.StatefulSetUpdateStrategy, status appsv1.StatefulSetStatus) *appsv1.StatefulSet {
+		replicas := int32(3)
+		return &appsv1.StatefulSet{
+			ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "test", Generation: 2},
+			Spec:       appsv1.StatefulSetSpec{Replicas: &replicas, UpdateStrategy: strategy},
+			Status:     status,
+		}
+	}
+	partition := int32(2)
+	partitioned := appsv1.StatefulSetUpdateStrategy{
+		Type:          appsv1.RollingUpdateStatefulSetStrategyType,
+		RollingUpdate: &appsv1.RollingUpdateStatefulSetStrategy{Partition: &partition},
+	}
+	onDelete := appsv1.StatefulSetUpdateStrategy{Type: appsv1.OnDeleteStatefulSetStrategyType}
+
+	tests := []struct {
+		name        string
+		statefulSet *appsv1.StatefulSet
+		ready       bool
+	}{
+		{
+			name:        "rolled out",
+			statefulSet: newStatefulSet(appsv1.StatefulSetUpdateStrategy{}, appsv1.StatefulSetStatus{ObservedGeneration: 2, ReadyReplicas: 3, CurrentRevision: "db-2", UpdateRevision: "db-2"}),
+			ready:       true,
+		},
+		{
+			name:        "generation not observed",
+			statefulSet: newStatefulSet(appsv1.StatefulSetUpdateStrategy{}, appsv1.StatefulSetStatus{ObservedGeneration: 1, ReadyReplicas: 3, CurrentRevision: "db-1", UpdateRevision: "db-1"}),
+		},
+		{
+			name:        "replicas not ready",
+			statefulSet: newStatefulSet(appsv1.StatefulSetUpdateStrategy{}, appsv1.StatefulSetStatus{ObservedGeneration: 2, ReadyReplicas: 2, CurrentRevision: "db-2", UpdateRevision: "db-2"}),
+		},
+		{
+			name:        "revision not rolled out",
+			statefulSet: newStatefulSet(appsv1.StatefulSetUpdateStrategy{}, appsv1.StatefulSetStatus{ObservedGeneration: 2, ReadyReplicas: 3, CurrentRevision: "db-1", UpdateRevision: "db-2"}),
+		},
+		{
+			name:        "partition rolled out",
+			statefulSet: newStatefulSet(partitioned, appsv1.StatefulSetStatus{ObservedGeneration: 2, ReadyReplicas: 3, UpdatedReplicas: 1, CurrentRevision: "db-1", UpdateRevision: "db-2"}),
+			ready:       true,
+		},
+		{
+			name:        "partition not rolled out",
+			statefulSet: newStatefulSet(partitioned, appsv1.StatefulSetStatus{ObservedGeneration: 2, ReadyReplicas: 3, CurrentRevision: "db-1", UpdateRevision: "db-2"}),
+		},
+		{
+			name:        "on delete",
+			statefulSet: newStatefulSet(onDelete, appsv1.StatefulSetStatus{ObservedGeneration: 2, ReadyReplicas: 3, CurrentRevision: "db-1", UpdateRevision: "db-2"}),
+			ready:       true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ready, err := IsStatefulSetReady(test.statefulSet)
+			assert.NoError(t, err)
+			assert.Equal(t, test.ready, ready)
+		})
+	}
+}
+
+func TestIsDaemonSetReady(t *testing.T) {
+	newDaemonSet := func(strategy appsv1.DaemonSetUpdateStrategyType, status appsv1.DaemonSetStatus) *appsv1.DaemonSet {
+		return &appsv1.DaemonSet{
+			ObjectMeta: metav1.ObjectMeta{Name: "agent", Namespace: "test", Generation: 2},
+			Spec:       appsv1.DaemonSetSpec{UpdateStrategy: appsv1.DaemonSetUpdateStrategy{Type: strategy}},
+			Status:     status,
+		}
+	}
+
+	tests := []struct {
+		name      string
+		daemonSet *appsv1.DaemonSet
+		ready     bool
+	}{
+		{
+			name:      "rolled out",
+			daemonSet: newDaemonSet(appsv1.RollingUpdateDaemonSetStrategyType, appsv1.DaemonSetStatus{ObservedGeneration: 2, DesiredNumberScheduled: 3, UpdatedNumberScheduled: 3, NumberAvailable: 3}),
+			ready:     true,
+		},
+		{
+			name:      "generation not observed",
+			daemonSet: newDaemonSet(appsv1.RollingUpdateDaemonSetStrategyType, appsv1.DaemonSetStatus{ObservedGeneration: 1, DesiredNumberScheduled: 3, UpdatedNumberScheduled: 3, NumberAvailable: 3}),
+		},
+		{
+			name:      "pods not updated",
+			daemonSet: newDaemonSet(appsv1.RollingUpdateDaemonSetStrategyType, appsv1.DaemonSetStatus{ObservedGeneration: 2, DesiredNumberScheduled: 3, UpdatedNumberScheduled: 2, NumberAvailable: 3}),
+		},
+		{
+			name:      "pods unavailable",
+			daemonSet: newDaemonSet(appsv1.RollingUpdateDaemonSetStrategyType, appsv1.DaemonSetStatus{ObservedGeneration: 2, DesiredNumberScheduled: 3, UpdatedNumberScheduled: 3, NumberAvailable: 2, NumberUnavailable: 1}),
+		},
+		{
+			name:      "on delete",
+			daemonSet: newDaemonSet(appsv1.OnDeleteDaemonSetStrategyType, appsv1.DaemonSetStatus{ObservedGeneration: 2, DesiredNumberScheduled: 3, UpdatedNumberScheduled: 1, NumberAvailable: 3}),
+			ready:     true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ready, err := IsDaemonSetReady(test.daemonSet)
+			assert.NoError(t, err)
+			assert.Equal(t, test.ready, ready)
+		})
+	}
+}
+
+func TestIsReplicaSetReady(t *testing.T) {
+	replicas := int32(2)
+	replicaSet := &appsv1.ReplicaSet{
+		ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "test", Generation: 1},
+		Spec:       appsv1.ReplicaSetSpec{Replicas: &replicas},
+		Status:     appsv1.ReplicaSetStatus{ObservedGeneration: 1, AvailableReplicas: 1},
+	}
+	ready, err := IsReplicaSetReady(replicaSet)
+	assert.NoError(t, err)
+	assert.False(t, ready)
+
+	replicaSet.Status.AvailableReplicas = 2
+	ready, err = IsReplicaSetReady(replicaSet)
+	assert.NoError(t, err)
+	assert.True(t, ready)
+
+	replicaSet.Status.Conditions = []appsv1.ReplicaSetCondition{{
+		Type:    appsv1.ReplicaSetReplicaFailure,
+		Status:  corev1.ConditionTrue,
+		Reason:  "FailedCreate",
+		Message: "exceeded quota",
+	}}
+	_, err = IsReplicaSetReady(replicaSet)
+	assert.True(t, IsResourceFailedError(err))
+	assert.EqualError(t, err, "test/app failed: FailedCreate: exceeded quota")
+}
+
+func TestIsPodReady(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "test"},
+		Status: corev1.PodStatus{
+			Phase:      corev1.PodRunning,
+			Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionFalse}},
+		},
+	}
+	ready, err := IsPodReady(pod)
+	assert.NoError(t, err)
+	assert.False(t, ready)
+
+	pod.Status.Conditions[0].Status = corev1.ConditionTrue
+	ready, err = IsPodReady(pod)
+	assert.NoError(t, err)
+	assert.True(t, ready)
+
+	pod.Status.Phase = corev1.PodFailed
+	pod.Status.Reason = "Evicted"
+	pod.Status.Message = "The node was low on resource: memory."
+	_, err = IsPodReady(pod)
+	assert.True(t, IsResourceFailedError(err))
+	assert.EqualError(t, err, "test/app failed: Evicted: The node was low on resource: memory.")
+}
+
+func TestIsPersistentVolumeClaimReady(t *testing.T) {
+	claim := &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: "data", Namespace: "test"},
+		Status:     corev1.PersistentVolumeClaimStatus{Phase: corev1.ClaimPending},
+	}
+	ready, err := IsPersistentVolumeClaimReady(claim)
+	assert.NoError(t, err)
+	assert.False(t, ready)
+
+	claim.Status.Phase = corev1.ClaimBound
+	ready, err = IsPersistentVolumeClaimReady(claim)
+	assert.NoError(t, err)
+	assert.True(t, ready)
+
+	claim.Status.Phase = corev1.ClaimLost
+	_, err = IsPersistentVolumeClaimReady(claim)
+	assert.True(t, IsResourceFailedError(err))
+}
+
+func TestIsServiceReady(t *testing.T) {
+	service := &corev1.Service{Spec: corev1.ServiceSpec{Type: corev1.ServiceTypeClusterIP}}
+	ready, err := IsServiceReady(service)
+	assert.NoError(t, err)
+	assert.True(t, ready)
+
+	service.Spec.Type = corev1.ServiceTypeLoadBalancer
+	ready, err = IsServiceReady(service)
+	assert.NoError(t, err)
+	assert.False(t, ready)
+
+	service.Status.LoadBalancer.Ingress = []corev1.LoadBalancerIngress{{IP: "192.0.2.10"}}
+	ready, err = IsServiceReady(service)
+	assert.NoError(t, err)
+	assert.True(t, ready)
+}
+
+func TestIsIngressReady(t *testing.T) {
+	ingress := &networkingv1.Ingress{}
+	ready, err := IsIngressReady(ingress)
+	assert.NoError(t, err)
+	assert.False(t, ready)
+
+	ingress.Status.LoadBalancer.Ingress = []corev1.LoadBalancerIngress{{Hostname: "lb.example.com"}}
+	ready, err = IsIngressReady(ingress)
+	assert.NoError(t, err)
+	assert.True(t, ready)
+}
+
+func TestIsRouteReady(t *testing.T) {
+	newRoute := func(ingress ...interface{}) *unstructured.Unstructured {
+		route := &unstructured.Unstructured{}
+		route.SetName("app")
+		route.SetNamespace("test")
+		_ = unstructured.SetNestedSlice(route.Object, ingress, "status", "ingress")
+		return route
+	}
+	routerIngress := func(conditions ...interface{}) interface{} {
+		return map[string]interface{}{"routerName": "default", "conditions": conditions}
+	}
+	admitted := map[string]interface{}{"type": "Admitted", "status": "True"}
+	rejected := map[string]interface{}{"type": "Admitted", "status": "False", "reason": "HostAlreadyClaimed", "message": "route app already exposes app.example.com"}
+
+	ready, err := IsRouteReady(newRoute())
+	assert.NoError(t, err)
+	assert.False(t, ready)
+
+	ready, err = IsRouteReady(newRoute(routerIngress(), routerIngress(admitted)))
+	assert.NoError(t, err)
+	assert.True(t, ready)
+
+	ready, err = IsRouteReady(newRoute(routerIngress(rejected), routerIngress()))
+	assert.NoError(t, err)
+	assert.False(t, ready)
+
+	_, err = IsRouteReady(newRoute(routerIngress(rejected)))
+	assert.True(t, IsResourceFailedError(err))
+	assert.EqualError(t, err, "test/app failed: HostAlreadyClaimed: route app already exposes app.example.com")
+}
+
+func TestIsCustomResourceDefinitionReady(t *testing.T) {
+	newCRD := func(conditions ...interface{}) *unstructured.Unstructured {
+		crd := &unstructured.Unstructured{}
+		crd.SetName("widgets.example.com")
+		_ = unstructured.SetNestedSlice(crd.Object, conditions, "status", "conditions")
+		return crd
+	}
+	namesAccepted := map[string]interface{}{"type": "NamesAccepted", "status": "True"}
+	established := map[string]interface{}{"type": "Established", "status": "True"}
+
+	ready, err := IsCustomResourceDefinitionReady(newCRD(namesAccepted))
+	assert.NoError(t, err)
+	assert.False(t, ready)
+
+	ready, err = IsCustomResourceDefinitionReady(newCRD(namesAccepted, established))
+	assert.NoError(t, err)
+	assert.True(t, ready)
+
+	_, err = IsCustomResourceDefinitionReady(newCRD(map[string]interface{}{"type": "NamesAccepted", "status": "False", "reason": "ListKindConflict"}))
+	assert.True(t, IsResourceFailedError(err))
+	assert.EqualError(t, err, "widgets.example.com failed: ListKindConflict")
+}
+
+func TestConditionReadiness(t *testing.T) {
+	newCertificate := func(generation int64, conditions ...interface{}) *unstructured.Unstructured {
+		certificate := &unstructured.Unstructured{}
+		certificate.SetKind("Certificate")
+		certificate.SetName("tls")
+		certificate.SetNamespace("test")
+		certificate.SetGeneration(generation)
+		_ = unstructured.SetNestedSlice(certificate.Object, conditions, "status", "conditions")
+		return certificate
+	}
+	readiness := ConditionReadiness{
+		Ready:              ConditionMatch{Type: "Ready"},
+		ObservedGeneration: true,
+		Failed:             []ConditionMatch{{Type: "Issuing", Status: "False", Reason: "Failed"}},
+	}
+
+	tests := []struct {
+		name        string
+		certificate *unstructured.Unstructured
+		ready       bool
+		err         string
+	}{
+		{
+			name:        "no conditions",
+			certificate: newCertificate(1),
+		},
+		{
+			name:        "ready",
+			certificate: newCertificate(1, map[string]interface{}{"type": "Ready", "status": "True", "observedGeneration": int64(1)}),
+			ready:       true,
+		},
+		{
+			name:        "not ready",
+			certificate: newCertificate(1, map[string]interface{}{"type": "Ready", "status": "False", "observedGeneration": int64(1)}),
+		},
+		{
+			name:        "generation not observed",
+			certificate: newCertificate(2, map[string]interface{}{"type": "Ready", "status": "True", "observedGeneration": int64(1)}),
+		},
+		{
+			name: "failed",
+			certificate: newCertificate(1,
+				map[string]interface{}{"type": "Ready", "status": "False"},
+				map[string]interface{}{"type": "Issuing", "status": "False", "reason": "Failed", "message": "issuer not found"},
+			),
+			err: "Certificate test/tls failed: Failed: issuer not found",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ready, err := readiness.IsReady(test.certificate)
+			if test.err != "" {
+				assert.True(t, IsResourceFailedError(err))
+				assert.EqualError(t, err, test.err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.ready, ready)
+		})
+	}
+}
+
+func TestConditionReadinessStatusObservedGeneration(t *testing.T) {
+	readiness := ConditionReadiness{Ready: ConditionMatch{Type: "Succeeded"}, ObservedGeneration: true}
+	csv := &unstructured.Unstructured{}
+	csv.SetGeneration(3)
+	_ = unstructured.SetNestedSlice(csv.Object, []interface{}{map[string]interface{}{"type": "Succeeded", "status": "True"}}, "status", "conditions")
+	_ = unstructured.SetNestedField(csv.Object, int64(2), "status", "observedGeneration")
+
+	ready, err := readiness.IsReady(csv)
+	assert.NoError(t, err)
+	assert.False(t, ready)
+
+	_ = unstructured.SetNestedField(csv.Object, int64(3), "status", "observedGeneration")
+	ready, err = readiness.IsReady(csv)
+	assert.NoError(t, err)
+	assert.True(t, ready)
+}
@@ -0,0 +1,187 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package actions
+
+import (
+	"context"
+	"reflect"
+	"sync"
+
+	"github.com/pkg/errors"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+)
+
+// ReadinessCheck reports whether obj is ready. obj is either the typed object or an
+// unstructured.Unstructured, and the client can be used to read related resources.
+type ReadinessCheck func(ctx context.Context, client client.Client, obj client.Object) (bool, error)
+
+// ReadinessRegistry holds the readiness check of every kind of resource. A check
+// registered with an empty version applies to all versions of its group and kind.
+type ReadinessRegistry struct {
+	lock   sync.RWMutex
+	checks map[schema.GroupVersionKind]ReadinessCheck
+}
+
+// DefaultReadinessRegistry is used by the ControllerActionRunner unless another registry
+// is set with WithReadinessRegistry. Operators register the checks of their CRDs here.
+var DefaultReadinessRegistry = NewReadinessRegistry()
+
+// NewReadinessRegistry returns a registry holding the checks of this package
+func NewReadinessRegistry() *ReadinessRegistry {
+	registry := &ReadinessRegistry{checks: map[schema.GroupVersionKind]ReadinessCheck{}}
+
+	registry.Register(appsv1.SchemeGroupVersion.WithKind("Deployment"), func(ctx context.Context, c client.Client, obj client.Object) (bool, error) {
+		deployment := &appsv1.Deployment{}
+		if err := asTyped(obj, deployment); err != nil {
+			return false, err
+		}
+		return IsDeploymentReady(deployment)
+	})
+	registry.Register(appsv1.SchemeGroupVersion.WithKind("StatefulSet"), func(ctx context.Context, c client.Client, obj client.Object) (bool, error) {
+		statefulSet := &appsv1.StatefulSet{}
+		if err := asTyped(obj, statefulSet); err != nil {
+			return false, err
+		}
+		return IsStatefulSetReady(statefulSet)
+	})
+	registry.Register(appsv1.SchemeGroupVersion.WithKind("DaemonSet"), func(ctx context.Context, c client.Client, obj client.Object) (bool, error) {
+		daemonSet := &appsv1.DaemonSet{}
+		if err := asTyped(obj, daemonSet); err != nil {
+			return false, err
+		}
+		return IsDaemonSetReady(daemonSet)
+	})
+	registry.Register(appsv1.SchemeGroupVersion.WithKind("ReplicaSet"), func(ctx context.Context, c client.Client, obj client.Object) (bool, error) {
+		replicaSet := &appsv1.ReplicaSet{}
+		if err := asTyped(obj, replicaSet); err != nil {
+			return false, err
+		}
+		return IsReplicaSetReady(replicaSet)
+	})
+	registry.Register(batchv1.SchemeGroupVersion.WithKind("Job"), func(ctx context.Context, c client.Client, obj client.Object) (bool, error) {
+		job := &batchv1.Job{}
+		if err := asTyped(obj, job); err != nil {
+			return false, err
+		}
+		return IsJobReady(job)
+	})
+	registry.Register(corev1.SchemeGroupVersion.WithKind("Pod"), func(ctx context.Context, c client.Client, obj client.Object) (bool, error) {
+		pod := &corev1.Pod{}
+		if err := asTyped(obj, pod); err != nil {
+			return false, err
+		}
+		return IsPodReady(pod)
+	})
+	registry.Register(corev1.SchemeGroupVersion.WithKind("Endpoints"), func(ctx context.Context, c client.Client, obj client.Object) (bool, error) {
+		endpoints := &corev1.Endpoints{}
+		if err := asTyped(obj, endpoints); err != nil {
+			return false, err
+		}
+		return IsEndpointsReady(endpoints)
+	})
+	registry.Register(corev1.SchemeGroupVersion.WithKind("PersistentVolumeClaim"), func(ctx context.Context, c client.Client, obj client.Object) (bool, error) {
+		claim := &corev1.PersistentVolumeClaim{}
+		if err := asTyped(obj, claim); err != nil {
+			return false, err
+		}
+		return IsPersistentVolumeClaimReady(claim)
+	})
+	registry.Register(corev1.SchemeGroupVersion.WithKind("Service"), func(ctx context.Context, c client.Client, obj client.Object) (bool, error) {
+		service := &corev1.Service{}
+		if err := asTyped(obj, service); err != nil {
+			return false, err
+		}
+		return IsServiceReady(service)
+	})
+	registry.Register(networkingv1.SchemeGroupVersion.WithKind("Ingress"), func(ctx context.Context, c client.Client, obj client.Object) (bool, error) {
+		ingress := &networkingv1.Ingress{}
+		if err := asTyped(obj, ingress); err != nil {
+			return false, err
+		}
+		return IsIngressReady(ingress)
+	})
+
+	registry.Register(schema.GroupVersionKind{Group: "route.openshift.io", Kind: "Route"}, unstructuredCheck(IsRouteReady))
+	registry.Register(schema.GroupVersionKind{Group: "apiextensions.k8s.io", Kind: "CustomResourceDefinition"}, unstructuredCheck(IsCustomResourceDefinitionReady))
+
+	registry.Register(schema.GroupVersionKind{Group: "maistra.io", Kind: "ServiceMeshControlPlane"}, serviceMeshControlPlaneReadiness.Check())
+	registry.Register(schema.GroupVersionKind{Group: "maistra.io", Kind: "ServiceMeshMemberRoll"}, serviceMeshMemberRollReadiness.Check())
+	registry.Register(schema.GroupVersionKind{Group: "maistra.io", Kind: "ServiceMeshMember"}, serviceMeshMemberReadiness.Check())
+
+	return registry
+}
+
+// Register sets the readiness check of gvk, replacing the previous one
+func (r *ReadinessRegistry) Register(gvk schema.GroupVersionKind, check ReadinessCheck) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.checks[gvk] = check
+}
+
+// IsReady runs the readiness check registered for the kind of obj. Typed objects without
+// type information are resolved with the scheme of the client.
+func (r *ReadinessRegistry) IsReady(ctx context.Context, c client.Client, obj client.Object) (bool, error) {
+	gvk := obj.GetObjectKind().GroupVersionKind()
+	if gvk.Kind == "" {
+		var err error
+		gvk, err = apiutil.GVKForObject(obj, c.Scheme())
+		if err != nil {
+			return false, err
+		}
+	}
+
+	check := r.lookup(gvk)
+	if check == nil {
+		return false, errors.Errorf("no readiness check for %v", gvk.Kind)
+	}
+	return check(ctx, c, obj)
+}
+
+func (r *ReadinessRegistry) lookup(gvk schema.GroupVersionKind) ReadinessCheck {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	if check, found := r.checks[gvk]; found {
+		return check
+	}
+	return r.checks[gvk.GroupKind().WithVersion("")]
+}
+
+// asTyped sets into to obj, converting obj if it is an unstructured.Unstructured
+func asTyped(obj client.Object, into client.Object) error {
+	if reflect.TypeOf(obj) == reflect.TypeOf(into) {
+		reflect.ValueOf(into).Elem().Set(reflect.ValueOf(obj).Elem())
+		return nil
+	}
+	if u, ok := obj.(*unstructured.Unstructured); ok {
+		return runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, into)
+	}
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return err
+	}
+	return runtime.DefaultUnstructuredConverter.FromUnstructured(content, into)
+}
+
+// unstructuredCheck adapts a check of unstructured objects, converting typed objects
+func unstructuredCheck(check func(*unstructured.Unstructured) (bool, error)) ReadinessCheck {
+	return func(ctx context.Context, c client.Client, obj client.Object) (bool, error) {
+		if u, ok := obj.(*unstructured.Unstructured); ok {
+			return check(u)
+		}
+		content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+		if err != nil {
+			return false, err
+		}
+		return check(&unstructured.Unstructured{Object: content})
+	}
+}
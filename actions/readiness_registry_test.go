@@ -0,0 +1,87 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package actions
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestReadinessRegistry(t *testing.T) {
+	cl := fake.NewClientBuilder().WithScheme(newTestScheme()).Build()
+	registry := NewReadinessRegistry()
+
+	// Typed objects without type information
+	endpoints := &corev1.Endpoints{Subsets: []corev1.EndpointSubset{{Addresses: []corev1.EndpointAddress{{IP: "10.0.0.1"}}}}}
+	ready, err := registry.IsReady(context.TODO(), cl, endpoints)
+	assert.NoError(t, err)
+	assert.True(t, ready)
+
+	job := &unstructured.Unstructured{}
+	job.SetGroupVersionKind(batchv1.SchemeGroupVersion.WithKind("Job"))
+	assert.NoError(t, unstructured.SetNestedField(job.Object, int64(1), "status", "succeeded"))
+	ready, err = registry.IsReady(context.TODO(), cl, job)
+	assert.NoError(t, err)
+	assert.True(t, ready)
+
+	smcp := &unstructured.Unstructured{}
+	smcp.SetGroupVersionKind(schema.GroupVersionKind{Group: "maistra.io", Version: "v2", Kind: "ServiceMeshControlPlane"})
+	assert.NoError(t, unstructured.SetNestedSlice(smcp.Object, []interface{}{
+		map[string]interface{}{"type": "Ready", "status": "True", "reason": "ComponentsReady"},
+	}, "status", "conditions"))
+	ready, err = registry.IsReady(context.TODO(), cl, smcp)
+	assert.NoError(t, err)
+	assert.True(t, ready)
+
+	certificate := &unstructured.Unstructured{}
+	certificate.SetGroupVersionKind(schema.GroupVersionKind{Group: "cert-manager.io", Version: "v1", Kind: "Certificate"})
+	assert.NoError(t, unstructured.SetNestedSlice(certificate.Object, []interface{}{
+		map[string]interface{}{"type": "Ready", "status": "True"},
+	}, "status", "conditions"))
+	registry.Register(certificate.GroupVersionKind(), ConditionReadiness{Ready: ConditionMatch{Type: "Ready"}}.Check())
+	ready, err = registry.IsReady(context.TODO(), cl, certificate)
+	assert.NoError(t, err)
+	assert.True(t, ready)
+
+	widget := &unstructured.Unstructured{}
+	widget.SetGroupVersionKind(schema.GroupVersionKind{Group: "example.com", Version: "v1", Kind: "Widget"})
+	_, err = registry.IsReady(context.TODO(), cl, widget)
+	assert.EqualError(t, err, "no readiness check for Widget")
+
+	registry.Register(widget.GroupVersionKind(), func(ctx context.Context, c client.Client, obj client.Object) (bool, error) {
+		return obj.GetName() == "ready", nil
+	})
+	widget.SetName("ready")
+	ready, err = registry.IsReady(context.TODO(), cl, widget)
+	assert.NoError(t, err)
+	assert.True(t, ready)
+}
+
+func TestWaitForReadyRegistry(t *testing.T) {
+	scheme := newTestScheme()
+	widget := &unstructured.Unstructured{}
+	widget.SetGroupVersionKind(schema.GroupVersionKind{Group: "example.com", Version: "v1", Kind: "Widget"})
+	widget.SetName("widget")
+	widget.SetNamespace("test")
+	cl := fake.NewClientBuilder().WithScheme(scheme).WithObjects(widget.DeepCopy()).Build()
+
+	registry := NewReadinessRegistry()
+	registry.Register(schema.GroupVersionKind{Group: "example.com", Kind: "Widget"}, func(ctx context.Context, c client.Client, obj client.Object) (bool, error) {
+		return false, nil
+	})
+	runner := NewControllerActionRunner(context.TODO(), cl, scheme, newTestOwner(), WithReadinessRegistry(registry))
+
+	_, err := WaitForReadyAction{Ref: widget}.Run(runner)
+	assert.True(t, IsResourceNotReadyError(err))
+	assert.EqualError(t, err, "Widget test/widget is not ready")
+}
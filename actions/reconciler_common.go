@@ -6,13 +6,20 @@ package actions
 
 import (
 	"context"
+	"fmt"
+	"strings"
 	"time"
 
 	"github.com/jeesmon/operator-utils/status"
 	conditions "github.com/openshift/custom-resource-status/conditions/v1"
+	"github.com/pkg/errors"
 	v1 "k8s.io/api/core/v1"
+	apiErrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/util/retry"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 )
 
@@ -21,27 +28,51 @@ const (
 	RequeueDelayError = 5 * time.Second
 )
 
+// DefaultConflictRetry is used to retry updates and status writes after a conflict
+var DefaultConflictRetry = retry.DefaultBackoff
+
 type ResourceState interface {
 	Read(context.Context, client.Object) error
 	IsResourcesReady(client.Object) (bool, error)
 }
 
-func ManageError(client client.Client, ctx context.Context, instance client.Object, statusConditions *[]conditions.Condition, issue error) (reconcile.Result, error) {
+func ManageError(client client.Client, ctx context.Context, instance client.Object, statusConditions *[]conditions.Condition, issue error, opts ...RunnerOption) (reconcile.Result, error) {
+	condition := issueCondition(issue, status.ReasonInitializing, status.ReasonFailing)
+	return manageIssue(client, ctx, instance, statusConditions, condition, issue, opts)
+}
+
+// issueCondition returns the condition describing issue, with notReadyReason when the
+// issue is only a resource that is not ready yet and failingReason otherwise
+func issueCondition(issue error, notReadyReason status.StatusReason, failingReason status.StatusReason) conditions.Condition {
 	condition := conditions.Condition{
 		Type:    conditions.ConditionAvailable,
 		Status:  v1.ConditionFalse,
 		Message: issue.Error(),
 	}
 
-	if IsResourceNotReadyError(issue) {
-		condition.Reason = string(status.ReasonInitializing)
+	notReady := IsResourceNotReadyError(issue)
+
+	var aggregate *AggregateActionError
+	if errors.As(issue, &aggregate) {
+		// List every failing resource instead of the first failure only
+		notReady = aggregate.IsResourceNotReady()
+		condition.Message = fmt.Sprintf("Failing resources: %s", strings.Join(aggregate.FailingResources(), ", "))
+	}
+
+	if notReady {
+		condition.Reason = string(notReadyReason)
 	} else {
-		condition.Reason = string(status.ReasonFailing)
+		condition.Reason = string(failingReason)
 	}
 
+	return condition
+}
+
+func manageIssue(client client.Client, ctx context.Context, instance client.Object, statusConditions *[]conditions.Condition, condition conditions.Condition, issue error, opts []RunnerOption) (reconcile.Result, error) {
 	conditions.SetStatusCondition(statusConditions, condition)
+	observeReconcile("error", condition.Reason)
 
-	err := client.Status().Update(ctx, instance)
+	err := newControllerActionRunner(ctx, client, client.Scheme(), instance, opts).updateStatus()
 	if err != nil {
 		log.Error(err, "unable to update status")
 		return reconcile.Result{
@@ -56,7 +87,7 @@ func ManageError(client client.Client, ctx context.Context, instance client.Obje
 	}, issue
 }
 
-func ManageSuccess(client client.Client, ctx context.Context, instance client.Object, statusConditions *[]conditions.Condition, resourcesReady bool) (reconcile.Result, error) {
+func ManageSuccess(client client.Client, ctx context.Context, instance client.Object, statusConditions *[]conditions.Condition, resourcesReady bool, opts ...RunnerOption) (reconcile.Result, error) {
 	condition := conditions.Condition{
 		Type: conditions.ConditionAvailable,
 	}
@@ -73,8 +104,9 @@ func ManageSuccess(client client.Client, ctx context.Context, instance client.Ob
 	}
 
 	conditions.SetStatusCondition(statusConditions, condition)
+	observeReconcile("success", condition.Reason)
 
-	err := client.Status().Update(ctx, instance)
+	err := newControllerActionRunner(ctx, client, client.Scheme(), instance, opts).updateStatus()
 	if err != nil {
 		log.Error(err, "unable to update status")
 		return reconcile.Result{
@@ -86,27 +118,123 @@ func ManageSuccess(client client.Client, ctx context.Context, instance client.Ob
 	return reconcile.Result{RequeueAfter: RequeueDelay}, nil
 }
 
-func RunDesiredStateActions(client client.Client, scheme *runtime.Scheme, ctx context.Context, instance client.Object, conditions *[]conditions.Condition, currentState ResourceState, desiredState DesiredResourceState) (reconcile.Result, error) {
+func RunDesiredStateActions(client client.Client, scheme *runtime.Scheme, ctx context.Context, instance client.Object, conditions *[]conditions.Condition, currentState ResourceState, desiredState DesiredResourceState, opts ...RunnerOption) (reconcile.Result, error) {
 	// Run the actions to reach the desired state
-	actionRunner := NewControllerActionRunner(ctx, client, scheme, instance)
-	err := actionRunner.RunAll(desiredState)
+	actionRunner := NewControllerActionRunner(ctx, client, scheme, instance, opts...)
+	_, err := actionRunner.RunAll(desiredState)
 	if err != nil {
-		return ManageError(client, ctx, instance, conditions, err)
+		return ManageError(client, ctx, instance, conditions, err, opts...)
 	}
 
 	resourcesReady, err := currentState.IsResourcesReady(instance)
 	if err != nil {
-		return ManageError(client, ctx, instance, conditions, err)
+		return ManageError(client, ctx, instance, conditions, err, opts...)
+	}
+
+	return ManageSuccess(client, ctx, instance, conditions, resourcesReady, opts...)
+}
+
+// ManageDeletion adds finalizer to a CR that is not being deleted. For a CR with a deletion
+// timestamp it runs cleanupState and removes finalizer only once the cleanup succeeded;
+// cleanup failures are set as a condition and the CR is requeued. Resources written with
+// Ownership.Fallback are deleted after cleanupState. When deleting is true,
+// the CR is being deleted and the reconcile should return the result and error.
+func ManageDeletion(client client.Client, scheme *runtime.Scheme, ctx context.Context, instance client.Object, statusConditions *[]conditions.Condition, finalizer string, cleanupState DesiredResourceState, opts ...RunnerOption) (deleting bool, result reconcile.Result, err error) {
+	actionRunner := newControllerActionRunner(ctx, client, scheme, instance, opts)
+
+	if instance.GetDeletionTimestamp().IsZero() {
+		_, err = AddFinalizerAction{Ref: instance, Finalizer: finalizer, Msg: "Add finalizer"}.Run(actionRunner)
+		if err != nil {
+			result, err = ManageError(client, ctx, instance, statusConditions, err, opts...)
+			return false, result, err
+		}
+		return false, reconcile.Result{}, nil
+	}
+
+	if !controllerutil.ContainsFinalizer(instance, finalizer) {
+		return true, reconcile.Result{}, nil
+	}
+
+	_, err = actionRunner.RunAll(cleanupState)
+	if err == nil {
+		// Resources without owner reference are not garbage collected with the CR
+		var fallbackState DesiredResourceState
+		fallbackState, err = actionRunner.fallbackCleanupState()
+		if err == nil {
+			_, err = actionRunner.RunAll(fallbackState)
+		}
+	}
+	if err != nil {
+		condition := issueCondition(err, status.ReasonTerminating, status.ReasonCleanupFailed)
+		result, err = manageIssue(client, ctx, instance, statusConditions, condition, err, opts)
+		return true, result, err
 	}
 
-	return ManageSuccess(client, ctx, instance, conditions, resourcesReady)
+	_, err = RemoveFinalizerAction{Ref: instance, Finalizer: finalizer, Msg: "Remove finalizer"}.Run(actionRunner)
+	if err != nil {
+		return true, reconcile.Result{
+			RequeueAfter: RequeueDelayError,
+			Requeue:      true,
+		}, err
+	}
+
+	return true, reconcile.Result{}, nil
 }
 
-func ReadCurrentState(client client.Client, ctx context.Context, instance client.Object, conditions *[]conditions.Condition, currentState ResourceState) (reconcile.Result, error) {
+func ReadCurrentState(client client.Client, ctx context.Context, instance client.Object, conditions *[]conditions.Condition, currentState ResourceState, opts ...RunnerOption) (reconcile.Result, error) {
 	err := currentState.Read(ctx, instance)
 	if err != nil {
-		return ManageError(client, ctx, instance, conditions, err)
+		return ManageError(client, ctx, instance, conditions, err, opts...)
 	}
 
 	return reconcile.Result{}, nil
 }
+
+// updateStatus writes the status of the CR. On a conflict the CR is re-read and its
+// status is reapplied on top of the latest version before retrying.
+func (i *ControllerActionRunner) updateStatus() error {
+	desired, err := runtime.DefaultUnstructuredConverter.ToUnstructured(i.cr.DeepCopyObject())
+	if err != nil {
+		return err
+	}
+
+	return retry.RetryOnConflict(i.conflictRetry, func() error {
+		err := i.client.Status().Update(i.context, i.cr)
+		if !apiErrors.IsConflict(err) {
+			return err
+		}
+
+		getErr := i.client.Get(i.context, client.ObjectKeyFromObject(i.cr), i.cr)
+		if getErr != nil {
+			return getErr
+		}
+		reapplyErr := setStatus(i.cr, desired["status"])
+		if reapplyErr != nil {
+			return reapplyErr
+		}
+		return err
+	})
+}
+
+// setStatus replaces the status of obj
+func setStatus(obj client.Object, status interface{}) error {
+	if u, ok := obj.(*unstructured.Unstructured); ok {
+		if status == nil {
+			delete(u.Object, "status")
+		} else {
+			u.Object["status"] = status
+		}
+		return nil
+	}
+
+	fields, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return err
+	}
+	if status == nil {
+		delete(fields, "status")
+	} else {
+		fields["status"] = status
+	}
+	return runtime.DefaultUnstructuredConverter.FromUnstructured(fields, obj)
+}
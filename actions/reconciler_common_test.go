@@ -0,0 +1,96 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package actions
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jeesmon/operator-utils/status"
+	conditions "github.com/openshift/custom-resource-status/conditions/v1"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	apiErrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestManageSuccessRetriesStatusConflict(t *testing.T) {
+	scheme := newTestScheme()
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "cr", Namespace: "test"}}
+	cl := fake.NewClientBuilder().WithScheme(scheme).WithObjects(pod).Build()
+
+	instance := &corev1.Pod{}
+	assert.NoError(t, cl.Get(context.TODO(), client.ObjectKeyFromObject(pod), instance))
+
+	// Someone else updates the CR, so instance is stale
+	live := instance.DeepCopy()
+	live.Labels = map[string]string{"updated": "true"}
+	assert.NoError(t, cl.Update(context.TODO(), live))
+
+	instance.Status.Message = "reconciled"
+	var statusConditions []conditions.Condition
+	_, err := ManageSuccess(cl, context.TODO(), instance, &statusConditions, true)
+	assert.NoError(t, err)
+
+	assert.NoError(t, cl.Get(context.TODO(), client.ObjectKeyFromObject(pod), live))
+	assert.Equal(t, "reconciled", live.Status.Message)
+	assert.Equal(t, "true", live.Labels["updated"])
+
+	// Without retries the conflict is returned
+	instance.ResourceVersion = "1"
+	_, err = ManageSuccess(cl, context.TODO(), instance, &statusConditions, true, WithConflictRetry(wait.Backoff{Steps: 1}))
+	assert.Error(t, err)
+}
+
+func TestManageDeletion(t *testing.T) {
+	scheme := newTestScheme()
+	const finalizer = "test/finalizer"
+	instance := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "cr", Namespace: "test"}}
+	cl := fake.NewClientBuilder().WithScheme(scheme).WithObjects(instance).Build()
+	assert.NoError(t, cl.Get(context.TODO(), client.ObjectKeyFromObject(instance), instance))
+	var statusConditions []conditions.Condition
+
+	// A concurrent edit of the CR is kept when the finalizer is added
+	edited := instance.DeepCopy()
+	edited.Spec.NodeName = "edited"
+	assert.NoError(t, cl.Update(context.TODO(), edited))
+
+	deleting, _, err := ManageDeletion(cl, scheme, context.TODO(), instance, &statusConditions, finalizer, nil)
+	assert.NoError(t, err)
+	assert.False(t, deleting)
+	assert.Equal(t, []string{finalizer}, instance.Finalizers)
+
+	live := &corev1.Pod{}
+	assert.NoError(t, cl.Get(context.TODO(), client.ObjectKeyFromObject(instance), live))
+	assert.Equal(t, "edited", live.Spec.NodeName)
+	assert.Equal(t, []string{finalizer}, live.Finalizers)
+	instance = live
+
+	now := metav1.Now()
+	instance.DeletionTimestamp = &now
+	assert.NoError(t, cl.Update(context.TODO(), instance))
+
+	// A failed cleanup keeps the finalizer
+	cleanupState := DesiredResourceState{
+		GenericDeleteAction{Ref: &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "missing", Namespace: "test"}}},
+	}
+	deleting, result, err := ManageDeletion(cl, scheme, context.TODO(), instance, &statusConditions, finalizer, cleanupState)
+	assert.Error(t, err)
+	assert.True(t, deleting)
+	assert.True(t, result.Requeue)
+	assert.Equal(t, string(status.ReasonCleanupFailed), statusConditions[0].Reason)
+	assert.Equal(t, []string{finalizer}, instance.Finalizers)
+
+	deleting, _, err = ManageDeletion(cl, scheme, context.TODO(), instance, &statusConditions, finalizer, nil)
+	assert.NoError(t, err)
+	assert.True(t, deleting)
+
+	// Removing the last finalizer lets the deletion complete
+	err = cl.Get(context.TODO(), client.ObjectKeyFromObject(instance), &corev1.Pod{})
+	assert.True(t, apiErrors.IsNotFound(err))
+}
@@ -0,0 +1,99 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package actions
+
+import (
+	"time"
+
+	"github.com/jeesmon/operator-utils/status"
+	objectreferences "github.com/openshift/custom-resource-status/objectreferences/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Outcome is the result of a single action, as logged by RunAll
+type Outcome string
+
+const (
+	OutcomeSuccess Outcome = "SUCCESS"
+	OutcomeFailed  Outcome = "FAILED"
+	OutcomeWaiting Outcome = "WAITING"
+	// OutcomeUnchanged is a successful action that skipped its write because the
+	// resource was already in the desired state
+	OutcomeUnchanged Outcome = "UNCHANGED"
+	// OutcomeRecreated is a successful action that had to delete its resource and create
+	// it again, because the update changed immutable fields
+	OutcomeRecreated Outcome = "RECREATED"
+	OutcomePlanned   Outcome = "PLANNED"
+	OutcomeSkipped   Outcome = "SKIPPED"
+)
+
+// ActionReport describes how a single action of a DesiredResourceState ran. Verb and
+// Object describe the last write the action made, and are empty for actions that
+// did not write anything.
+type ActionReport struct {
+	Index    int                     `json:"index"`
+	ID       string                  `json:"id"`
+	Msg      string                  `json:"msg"`
+	Verb     Verb                    `json:"verb,omitempty"`
+	Object   *corev1.ObjectReference `json:"object,omitempty"`
+	Duration time.Duration           `json:"duration"`
+	Outcome  Outcome                 `json:"outcome"`
+	Error    string                  `json:"error,omitempty"`
+}
+
+// RunReport holds a report for every action of a DesiredResourceState, in order
+type RunReport []ActionReport
+
+func newRunReport(desiredState DesiredResourceState) RunReport {
+	report := make(RunReport, len(desiredState))
+	for index, action := range desiredState {
+		report[index] = ActionReport{
+			Index:   index,
+			ID:      actionName(index, action),
+			Outcome: OutcomeSkipped,
+		}
+	}
+	return report
+}
+
+// record stores the result of an action in report and in the related objects, and
+// emits its event and metrics
+func (i *ControllerActionRunner) record(report RunReport, result actionResult) {
+	entry := &report[result.index]
+	entry.Msg = result.msg
+	entry.Verb = result.verb
+	entry.Duration = result.duration
+	entry.Outcome = result.outcome
+	if result.err != nil {
+		entry.Error = result.err.Error()
+	}
+	if result.target != nil {
+		entry.Object = i.objectReference(result.target)
+	}
+	i.emitEvent(*entry)
+	if i.dryRun == "" {
+		observeAction(*entry)
+	}
+
+	if result.target == nil {
+		return
+	}
+
+	if i.relatedObjects == nil || result.err != nil || i.dryRun != "" || result.target.GetUID() == i.cr.GetUID() && result.target.GetUID() != "" {
+		return
+	}
+	if result.verb == VerbDeleteCollection {
+		// The deleted resources are not known, so they stay in the related objects
+		return
+	}
+	if result.verb == VerbDelete {
+		objectreferences.RemoveObjectReference(i.relatedObjects, *entry.Object)
+		return
+	}
+	err := status.UpdateStatusRelatedObjects(i.relatedObjects, i.scheme, result.target)
+	if err != nil {
+		log.Error(err, "Error updating related objects")
+	}
+}
@@ -16,9 +16,11 @@ import (
 type StatusReason string
 
 var (
-	ReasonReconciling  StatusReason = "Reconciling"
-	ReasonFailing      StatusReason = "Failing"
-	ReasonInitializing StatusReason = "Initializing"
+	ReasonReconciling   StatusReason = "Reconciling"
+	ReasonFailing       StatusReason = "Failing"
+	ReasonInitializing  StatusReason = "Initializing"
+	ReasonTerminating   StatusReason = "Terminating"
+	ReasonCleanupFailed StatusReason = "CleanupFailed"
 )
 
 // CommonStatusSpec defines the Common Status Spec